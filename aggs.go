@@ -0,0 +1,271 @@
+package escrud
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Agg is an aggregation of a search, build it with the *Agg functions
+// and nest others with Sub:
+//
+//	TermsAgg("rubrics", "rubric", 10).Sub(
+//		DateHistogramAgg("by_day", "date", "1d"),
+//		AvgAgg("avg_views", "viewed"),
+//	)
+type Agg struct {
+	Name   string
+	typ    string
+	params map[string]interface{}
+	subs   []*Agg
+}
+
+func newAgg(name, typ string, params map[string]interface{}) *Agg {
+	return &Agg{Name: name, typ: typ, params: params}
+}
+
+// Param sets a parameter of the aggregation which has no helper, e.g. min_doc_count or order
+func (a *Agg) Param(key string, value interface{}) *Agg {
+	a.params[key] = value
+	return a
+}
+
+// Sub adds sub-aggregations computed for each bucket of the aggregation
+func (a *Agg) Sub(aggs ...*Agg) *Agg {
+	a.subs = append(a.subs, aggs...)
+	return a
+}
+
+// MarshalJSON encodes the aggregation as the value of its name in "aggs"
+func (a *Agg) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{a.typ: a.params}
+	if len(a.subs) > 0 {
+		subs := make(map[string]*Agg, len(a.subs))
+		for _, s := range a.subs {
+			subs[s.Name] = s
+		}
+		body["aggs"] = subs
+	}
+	return json.Marshal(body)
+}
+
+// TermsAgg groups documents by the values of the field, size most frequent of them
+func TermsAgg(name, field string, size int) *Agg {
+	return newAgg(name, "terms", map[string]interface{}{"field": field, "size": size})
+}
+
+// HistogramAgg groups documents by the numeric field into buckets of the interval
+func HistogramAgg(name, field string, interval float64) *Agg {
+	return newAgg(name, "histogram", map[string]interface{}{"field": field, "interval": interval})
+}
+
+// calendarIntervals are the intervals elastic knows as calendar ones, others are fixed
+var calendarIntervals = map[string]bool{
+	"1m": true, "minute": true, "1h": true, "hour": true, "1d": true, "day": true,
+	"1w": true, "week": true, "1M": true, "month": true, "1q": true, "quarter": true, "1y": true, "year": true,
+}
+
+// DateHistogramAgg groups documents by the date field into buckets of the interval.
+// Single calendar units like "1d", "1M" or "week" go as calendar_interval, others like "12h" or "90m" as fixed_interval.
+func DateHistogramAgg(name, field, interval string) *Agg {
+	key := "fixed_interval"
+	if calendarIntervals[interval] {
+		key = "calendar_interval"
+	}
+	return newAgg(name, "date_histogram", map[string]interface{}{"field": field, key: interval})
+}
+
+// RangeAgg groups documents by the numeric field into ranges between the bounds,
+// open-ended ranges below the first and above the last bound included:
+// bounds 10, 100 make ranges *-10, 10-100 and 100-*.
+func RangeAgg(name, field string, bounds ...float64) *Agg {
+	ranges := make([]map[string]interface{}, 0, len(bounds)+1)
+	for i := 0; i <= len(bounds); i++ {
+		r := map[string]interface{}{}
+		if i > 0 {
+			r["from"] = bounds[i-1]
+		}
+		if i < len(bounds) {
+			r["to"] = bounds[i]
+		}
+		ranges = append(ranges, r)
+	}
+	return newAgg(name, "range", map[string]interface{}{"field": field, "ranges": ranges})
+}
+
+// AvgAgg computes the average of the field
+func AvgAgg(name, field string) *Agg {
+	return newAgg(name, "avg", map[string]interface{}{"field": field})
+}
+
+// SumAgg computes the sum of the field
+func SumAgg(name, field string) *Agg {
+	return newAgg(name, "sum", map[string]interface{}{"field": field})
+}
+
+// MinAgg computes the minimum of the field
+func MinAgg(name, field string) *Agg {
+	return newAgg(name, "min", map[string]interface{}{"field": field})
+}
+
+// MaxAgg computes the maximum of the field
+func MaxAgg(name, field string) *Agg {
+	return newAgg(name, "max", map[string]interface{}{"field": field})
+}
+
+// CardinalityAgg counts distinct values of the field, approximately on big numbers
+func CardinalityAgg(name, field string) *Agg {
+	return newAgg(name, "cardinality", map[string]interface{}{"field": field})
+}
+
+// TopHitsAgg returns size top documents of each bucket of the parent aggregation
+func TopHitsAgg(name string, size int, sort ...SortField) *Agg {
+	params := map[string]interface{}{"size": size}
+	if len(sort) > 0 {
+		params["sort"] = sort
+	}
+	return newAgg(name, "top_hits", params)
+}
+
+// NestedAgg runs its sub-aggregations on the nested objects at the path
+func NestedAgg(name, path string) *Agg {
+	return newAgg(name, "nested", map[string]interface{}{"path": path})
+}
+
+// Aggregations are the results of aggregations by name, decode them with the typed getters
+type Aggregations map[string]json.RawMessage
+
+// Bucket is a bucket of terms, histogram, date_histogram, range and nested aggregations
+type Bucket struct {
+	// Key is a string or a float64 for terms, a float64 for histograms (milliseconds for dates)
+	// and the range key like "10.0-100.0" for ranges
+	Key         interface{}
+	KeyAsString string
+	DocCount    int64
+	From, To    *float64
+	// Aggregations are the results of the sub-aggregations in the bucket
+	Aggregations Aggregations
+}
+
+// Time returns the key of a date_histogram bucket as time
+func (b Bucket) Time() time.Time {
+	ms, _ := b.Key.(float64)
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC()
+}
+
+// UnmarshalJSON decodes the bucket, the keys which aren't bucket fields are sub-aggregations
+func (b *Bucket) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for k, v := range raw {
+		var err error
+		switch k {
+		case "key":
+			err = json.Unmarshal(v, &b.Key)
+		case "key_as_string":
+			err = json.Unmarshal(v, &b.KeyAsString)
+		case "doc_count":
+			err = json.Unmarshal(v, &b.DocCount)
+		case "from":
+			err = json.Unmarshal(v, &b.From)
+		case "to":
+			err = json.Unmarshal(v, &b.To)
+		default:
+			if strings.HasPrefix(strings.TrimSpace(string(v)), "{") {
+				if b.Aggregations == nil {
+					b.Aggregations = Aggregations{}
+				}
+				b.Aggregations[k] = v
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("cannot decode bucket [%s]: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// MetricResult is the result of avg, sum, min, max and cardinality aggregations
+type MetricResult struct {
+	// Value is nil for avg, min and max of no documents
+	Value         *float64 `json:"value"`
+	ValueAsString string   `json:"value_as_string,omitempty"`
+}
+
+func (a Aggregations) get(name string, v interface{}) error {
+	raw, ok := a[name]
+	if !ok {
+		return fmt.Errorf("no aggregation %s in the response", name)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("aggregation %s contains bad json: %v", name, err)
+	}
+	return nil
+}
+
+// Buckets returns the buckets of a terms, histogram, date_histogram or range aggregation
+func (a Aggregations) Buckets(name string) ([]Bucket, error) {
+	var res struct {
+		Buckets json.RawMessage `json:"buckets"`
+	}
+	if err := a.get(name, &res); err != nil {
+		return nil, err
+	}
+
+	var buckets []Bucket
+	if err := json.Unmarshal(res.Buckets, &buckets); err == nil {
+		return buckets, nil
+	}
+
+	// keyed buckets
+	var keyed map[string]Bucket
+	if err := json.Unmarshal(res.Buckets, &keyed); err != nil {
+		return nil, fmt.Errorf("aggregation %s has no buckets: %v", name, err)
+	}
+	keys := make([]string, 0, len(keyed))
+	for k := range keyed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b := keyed[k]
+		if b.Key == nil {
+			b.Key = k
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// Metric returns the value of an avg, sum, min, max or cardinality aggregation
+func (a Aggregations) Metric(name string) (*MetricResult, error) {
+	var res MetricResult
+	if err := a.get(name, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Nested returns the single bucket of a nested aggregation with the results of its sub-aggregations
+func (a Aggregations) Nested(name string) (*Bucket, error) {
+	var res Bucket
+	if err := a.get(name, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// TopHits returns the documents of a top_hits aggregation
+func (a Aggregations) TopHits(name string) ([]Hit, error) {
+	var res struct {
+		Hits hitsSection `json:"hits"`
+	}
+	if err := a.get(name, &res); err != nil {
+		return nil, err
+	}
+	return res.Hits.Hits, nil
+}
@@ -0,0 +1,169 @@
+package escrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// SortArrayItems упорядочить элементы массива по числовому полю, например mask_articles по position
+// POST http://localhost:9200/mask/_update/_3/
+// { "script": { "source": "ctx._source.mask_articles.sort((a, b) -> ...)", "params": { "desc": false } } }
+func (Es *Client) SortArrayItems(index string, docID string, arrayName string, itemName string, desc bool, opts ...WriteOption) (*ResponseBody, error) {
+	source := fmt.Sprintf(`ctx._source.%[1]s.sort((a, b) -> params.desc ? Double.compare(b.%[2]s, a.%[2]s) : Double.compare(a.%[2]s, b.%[2]s))`,
+		arrayName, itemName)
+
+	return Es.script("sort_array_items", index, docID, source, map[string]interface{}{
+		"desc": desc,
+	}, opts)
+}
+
+// MoveArrayItem переставить элемент массива, найденный по его параметру (пока только числовой ID), на позицию position.
+// Позиция за концом массива означает конец. Если элемента нет, документ не меняется (Result "noop").
+func (Es *Client) MoveArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, position int, opts ...WriteOption) (*ResponseBody, error) {
+	source := fmt.Sprintf(`def a = ctx._source.%[1]s; int from = -1; for (int i = 0; i < a.size(); i++) { if (a[i].%[2]s == params.id) { from = i; break; } } if (from < 0) { ctx.op = 'none' } else { def item = a.remove(from); a.add((int) Math.max(0, Math.min(params.position, a.size())), item) }`,
+		arrayName, itemName)
+
+	return Es.script("move_array_item", index, docID, source, map[string]interface{}{
+		"id":       itemValue,
+		"position": position,
+	}, opts)
+}
+
+// InsertArrayItemAt вставить элемент в массив на позицию position. Массива может не быть - тогда добавить и массив.
+// Позиция за концом массива означает конец.
+func (Es *Client) InsertArrayItemAt(index string, docID string, arrayName string, position int, elem []byte, opts ...WriteOption) (*ResponseBody, error) {
+	source := fmt.Sprintf(`if (!ctx._source.containsKey("%[1]s")) { ctx._source.%[1]s = [] } def a = ctx._source.%[1]s; a.add((int) Math.max(0, Math.min(params.position, a.size())), params.new)`,
+		arrayName)
+
+	return Es.script("insert_array_item_at", index, docID, source, map[string]interface{}{
+		"position": position,
+		"new":      json.RawMessage(elem),
+	}, opts)
+}
+
+// InsertArrayItemIfAbsent добавить элемент в конец массива, если в массиве нет элемента с тем же значением поля itemName.
+// Если такой есть, документ не меняется (Result "noop").
+func (Es *Client) InsertArrayItemIfAbsent(index string, docID string, arrayName string, itemName string, elem []byte, opts ...WriteOption) (*ResponseBody, error) {
+	source := fmt.Sprintf(`if (!ctx._source.containsKey("%[1]s")) { ctx._source.%[1]s = [] } def a = ctx._source.%[1]s; boolean found = false; for (def li : a) { if (li.%[2]s == params.new.%[2]s) { found = true } } if (found) { ctx.op = 'none' } else { a.add(params.new) }`,
+		arrayName, itemName)
+
+	return Es.script("insert_array_item_if_absent", index, docID, source, map[string]interface{}{
+		"new": json.RawMessage(elem),
+	}, opts)
+}
+
+// InsertArrayItems добавить несколько элементов в конец массива одним запросом. Массива может не быть - тогда добавить и массив.
+func (Es *Client) InsertArrayItems(index string, docID string, arrayName string, elems [][]byte, opts ...WriteOption) (*ResponseBody, error) {
+	items := make([]json.RawMessage, len(elems))
+	for i, elem := range elems {
+		items[i] = elem
+	}
+	source := fmt.Sprintf(`if (!ctx._source.containsKey("%[1]s")) { ctx._source.%[1]s = [] } ctx._source.%[1]s.addAll(params.new)`,
+		arrayName)
+
+	return Es.script("insert_array_items", index, docID, source, map[string]interface{}{
+		"new": items,
+	}, opts)
+}
+
+// CapArray оставить в массиве только первые max элементов.
+// Если массив короче, документ не меняется (Result "noop").
+func (Es *Client) CapArray(index string, docID string, arrayName string, max int, opts ...WriteOption) (*ResponseBody, error) {
+	source := fmt.Sprintf(`def a = ctx._source.%[1]s; if (a == null || a.size() <= params.max) { ctx.op = 'none' } else { ctx._source.%[1]s = new ArrayList(a.subList(0, params.max)) }`,
+		arrayName)
+
+	return Es.script("cap_array", index, docID, source, map[string]interface{}{
+		"max": max,
+	}, opts)
+}
+
+// patchArrayItemsScript merges params.patch into the items of the array at params.path
+// which match all of params.match, null values of the patch remove fields (RFC 7396)
+const patchArrayItemsScript = `
+def get(def o, def path) { for (def k : path) { if (!(o instanceof Map)) { return null; } o = o.get(k); } return o; }
+void merge(Map dst, Map patch) { for (def e : patch.entrySet()) { def v = e.getValue(); if (v == null) { dst.remove(e.getKey()); } else if (v instanceof Map && dst.get(e.getKey()) instanceof Map) { merge(dst.get(e.getKey()), v); } else { dst.put(e.getKey(), v); } } }
+def arr = get(ctx._source, params.path);
+int n = 0;
+if (arr instanceof List) { for (def li : arr) { boolean ok = li instanceof Map; for (def c : params.match) { if (ok && get(li, c.path) != c.value) { ok = false; } } if (ok) { merge(li, params.patch); n++; } } }
+if (n == 0) { ctx.op = 'none'; }
+`
+
+// PatchArrayItems изменить отдельные поля элементов массива, не заменяя элементы целиком.
+// arrayName и ключи match могут быть вложенными путями через точку, элемент должен совпасть по всем условиям match.
+// patch - JSON объект, который сливается с элементом (JSON merge patch: null удаляет поле).
+// Если ни один элемент не совпал, документ не меняется (Result "noop").
+//
+//	es.PatchArrayItems("mask", "_3", "mask_articles", map[string]interface{}{"article_id": 1886746}, []byte(`{"position": 5}`))
+func (Es *Client) PatchArrayItems(index string, docID string, arrayName string, match map[string]interface{}, patch []byte, opts ...WriteOption) (*ResponseBody, error) {
+	keys := make([]string, 0, len(match))
+	for k := range match {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	conditions := make([]map[string]interface{}, len(keys))
+	for i, k := range keys {
+		conditions[i] = map[string]interface{}{
+			"path":  strings.Split(k, "."),
+			"value": match[k],
+		}
+	}
+
+	return Es.script("patch_array_items", index, docID, patchArrayItemsScript, map[string]interface{}{
+		"path":  strings.Split(arrayName, "."),
+		"match": conditions,
+		"patch": json.RawMessage(patch),
+	}, opts)
+}
+
+// script runs the painless source with params on the document by the update API
+func (Es *Client) script(op string, index string, docID string, source string, params map[string]interface{}, opts []WriteOption) (upd *ResponseBody, err error) {
+	ctx, span := Es.startSpan(op, index, docID)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, op, index, docID, false)
+	defer func() { a.finish(nil, upd, err) }()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": source,
+			"lang":   "painless",
+			"params": params,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode script: %v", err)
+	}
+	a.scriptOf(body)
+
+	res, err := Es.Client.Update(
+		index,
+		docID,
+		bytes.NewReader(body),
+		Es.Client.Update.WithContext(ctx),
+		Es.write(opts).update,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot update entry: %v", err)
+	}
+	defer res.Body.Close()
+	spanStatus(ctx, res)
+
+	resp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response body: %v", err)
+	}
+
+	if res.IsError() {
+		return nil, fmt.Errorf("%s failed. Status: %s, err: %s", op, res.Status(), resp)
+	}
+
+	if err := json.Unmarshal(resp, &upd); err != nil {
+		return upd, fmt.Errorf("response contains bad json: %v", err)
+	}
+
+	return upd, nil
+}
@@ -0,0 +1,263 @@
+package escrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditRecord describes a write made through the Client
+type AuditRecord struct {
+	// Operation is the name of the write as in the spans: create, index, update, delete, increment, purge, ...
+	// Writes by BulkCreate and Bulk are recorded per action as bulk_index, bulk_create, bulk_update or bulk_delete.
+	Operation string `json:"operation"`
+	Index     string `json:"index"`
+	ID        string `json:"id,omitempty"`
+	// Actor is the one set to the context of the Client by WithActor
+	Actor string `json:"actor,omitempty"`
+	// Before is the source the document had before Index, Update, UpdateDiff, Delete and Restore,
+	// empty for a new document
+	Before json.RawMessage `json:"before,omitempty"`
+	// After is the source the document has after the write, read back after it. For bulk index and create
+	// actions it's the line of the document. It's empty for failed writes, deletes and other bulk actions.
+	After json.RawMessage `json:"after,omitempty"`
+	// Data is the data sent: the whole document of Create and Index, the partial one of Update
+	// and UpdateDiff, the mark of a soft Delete, the line of the document of a bulk action, the request of Purge
+	Data json.RawMessage `json:"data,omitempty"`
+	// Script is the script with its params of IncrementField, the array and field helpers
+	Script json.RawMessage `json:"script,omitempty"`
+	// Result is the result elastic reported: created, updated, deleted or noop
+	Result string `json:"result,omitempty"`
+	// Status is the HTTP status of a bulk action
+	Status int `json:"status,omitempty"`
+	// Count is the number of documents Purge deleted
+	Count    int64     `json:"count,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Error is the error of a failed write, or of a bulk action elastic refused
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink stores audit records. It's called synchronously after each write,
+// its errors are logged and don't fail the write.
+type AuditSink interface {
+	Audit(ctx context.Context, rec AuditRecord) error
+}
+
+// AuditFunc adapts a function to AuditSink
+type AuditFunc func(ctx context.Context, rec AuditRecord) error
+
+// Audit calls f
+func (f AuditFunc) Audit(ctx context.Context, rec AuditRecord) error {
+	return f(ctx, rec)
+}
+
+// WithAudit records the writes through the Client to the sink. Writes to a document read its source
+// after the write then, Index, Update, UpdateDiff, Delete and Restore before it too, which costs a request each.
+// Counter, Import and Copy write by their own bulk requests and aren't audited.
+//
+//	es, err := escrud.Connect(host, port, scheme, escrud.WithAudit(escrud.NewIndexAuditSink(auditES, "audit")))
+//	es.WithContext(escrud.WithActor(r.Context(), user.Login)).Update("article", id, data)
+func WithAudit(sink AuditSink) Option {
+	return func(c *Client) {
+		c.audit = sink
+	}
+}
+
+// AuditTo returns a shallow copy of the client recording its writes to the sink, nil turns auditing off.
+// The sink may write by the client itself:
+//
+//	es, err := escrud.Connect(host, port, scheme)
+//	audited := es.AuditTo(escrud.NewIndexAuditSink(es, "audit"))
+func (Es *Client) AuditTo(sink AuditSink) *Client {
+	c := *Es
+	c.audit = sink
+	return &c
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the actor of the writes made with it
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor set by WithActor, empty if there is none
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// IndexAuditSink writes audit records to an index of elastic, a document per record
+type IndexAuditSink struct {
+	es    *Client
+	index string
+}
+
+// NewIndexAuditSink creates an AuditSink writing to the index by es. Its writes aren't audited,
+// so es may be the audited Client itself, see AuditTo.
+func NewIndexAuditSink(es *Client, index string) *IndexAuditSink {
+	return &IndexAuditSink{es: es, index: index}
+}
+
+// Audit indexes the record with an id generated by elastic
+func (s *IndexAuditSink) Audit(ctx context.Context, rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("cannot encode audit record: %v", err)
+	}
+	// the call is the write of the record, not the audited one ctx carries
+	ctx = withCall(ctx, "audit", s.index, "")
+	_, err = put(ctx, s.es.Client, s.index, "", data, "create", s.es.write(nil))
+	return err
+}
+
+// auditEntry is an audit record in progress, nil if auditing is off
+type auditEntry struct {
+	es  *Client
+	ctx context.Context
+	rec AuditRecord
+}
+
+// startAudit starts the record of a write, reading the source of the document if before is set
+func (Es *Client) startAudit(ctx context.Context, op, index, id string, before bool) *auditEntry {
+	if Es.audit == nil {
+		return nil
+	}
+	a := &auditEntry{es: Es, ctx: ctx, rec: AuditRecord{
+		Operation: op,
+		Index:     index,
+		ID:        id,
+		Actor:     Actor(ctx),
+		Started:   time.Now(),
+	}}
+	if before && id != "" {
+		// a missing document has no before
+		if src, err := source(ctx, Es.Client, index, id); err == nil {
+			a.rec.Before = src
+		}
+	}
+	return a
+}
+
+// script records the script object of a scripted update
+func (a *auditEntry) script(script []byte) {
+	if a != nil && json.Valid(script) {
+		a.rec.Script = script
+	}
+}
+
+// scriptOf records the script of the update body {"script": ...}
+func (a *auditEntry) scriptOf(body []byte) {
+	if a == nil {
+		return
+	}
+	var req struct {
+		Script json.RawMessage `json:"script"`
+	}
+	if json.Unmarshal(body, &req) == nil {
+		a.script(req.Script)
+	}
+}
+
+// finish completes the record with the data sent, the result, the source after the write and the error,
+// and sends it to the sink
+func (a *auditEntry) finish(sent []byte, rb *ResponseBody, err error) {
+	if a == nil {
+		return
+	}
+	a.rec.Finished = time.Now()
+	if json.Valid(sent) {
+		a.rec.Data = sent
+	}
+	if rb != nil {
+		if a.rec.ID == "" {
+			a.rec.ID = rb.ID
+		}
+		a.rec.Result = rb.Result
+	}
+	if err != nil {
+		a.rec.Error = err.Error()
+	} else if a.rec.ID != "" && a.rec.Result != "deleted" {
+		if src, err := source(a.ctx, a.es.Client, a.rec.Index, a.rec.ID); err == nil {
+			a.rec.After = src
+		}
+	}
+	a.send()
+}
+
+// send sends the record to the sink
+func (a *auditEntry) send() {
+	if err := a.es.audit.Audit(a.ctx, a.rec); err != nil {
+		a.es.log().Error("cannot write audit record", "operation", a.rec.Operation, "index", a.rec.Index, "id", a.rec.ID, "error", err)
+	}
+}
+
+// auditBulk records the actions of the bulk request body with their results,
+// the actions numbered in marked are the soft deletes of markBulk
+func (Es *Client) auditBulk(ctx context.Context, started time.Time, datum []byte, marked map[int]bool, br *bulkResponse, err error) {
+	if Es.audit == nil {
+		return
+	}
+	finished := time.Now()
+	lines := bytes.Split(datum, []byte("\n"))
+	n := 0
+	for i := 0; i < len(lines); i++ {
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if json.Unmarshal(lines[i], &action) != nil {
+			continue
+		}
+		for name, meta := range action {
+			a := &auditEntry{es: Es, ctx: ctx, rec: AuditRecord{
+				Operation: "bulk_" + name,
+				Index:     meta.Index,
+				ID:        meta.ID,
+				Actor:     Actor(ctx),
+				Started:   started,
+				Finished:  finished,
+			}}
+			var data []byte
+			if name != "delete" && i+1 < len(lines) {
+				i++
+				data = lines[i]
+			}
+			if marked[n] {
+				// the soft delete, recorded as Delete records it, with the mark in Data
+				var update struct {
+					Doc json.RawMessage `json:"doc"`
+				}
+				json.Unmarshal(data, &update)
+				a.rec.Operation, data = "bulk_delete", update.Doc
+			}
+			if json.Valid(data) {
+				a.rec.Data = data
+			}
+			// the items of the response follow the actions
+			if br != nil && n < len(br.Items) {
+				item := br.Items[n].result()
+				if a.rec.Index == "" {
+					a.rec.Index = item.Index
+				}
+				if a.rec.ID == "" {
+					a.rec.ID = item.ID
+				}
+				a.rec.Result, a.rec.Status = item.Result, item.Status
+				if item.Error != nil {
+					a.rec.Error = item.Error.Error()
+				} else if name == "index" || name == "create" {
+					a.rec.After = a.rec.Data
+				}
+			}
+			n++
+			if err != nil {
+				a.rec.Error = err.Error()
+			}
+			a.send()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package escrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// bulkResponse is the answer of the bulk API, items are keyed by the action name
+type bulkResponse struct {
+	Took   int        `json:"took"`
+	Errors bool       `json:"errors"`
+	Items  []bulkItem `json:"items"`
+}
+
+// bulkItem is keyed by the action name: index, create, update or delete
+type bulkItem map[string]bulkResultItem
+
+type bulkResultItem struct {
+	Index  string     `json:"_index"`
+	ID     string     `json:"_id"`
+	Result string     `json:"result"`
+	Status int        `json:"status"`
+	Error  *bulkError `json:"error,omitempty"`
+}
+
+type bulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+func (e *bulkError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Reason)
+}
+
+// result returns the single action result of the item
+func (b bulkItem) result() bulkResultItem {
+	for _, item := range b {
+		return item
+	}
+	return bulkResultItem{}
+}
+
+// bulk sends NDJSON actions and decodes the per-item results
+func (Es *Client) bulk(ctx context.Context, body []byte, wo WriteOptions) (*bulkResponse, error) {
+	es := Es.Client
+	res, err := es.Bulk(
+		bytes.NewReader(body),
+		es.Bulk.WithContext(ctx),
+		wo.bulk,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot bulky send entries: %v", err)
+	}
+	defer res.Body.Close()
+	spanStatus(ctx, res)
+
+	resp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response body: %v", err)
+	}
+
+	if res.IsError() {
+		return nil, fmt.Errorf("bulk failed. Status: %s, err: %s", res.Status(), resp)
+	}
+
+	var br bulkResponse
+	if err := json.Unmarshal(resp, &br); err != nil {
+		return nil, fmt.Errorf("response contains bad json: %v", err)
+	}
+	return &br, nil
+}
+
+// BulkItemResult is the result of an action of a Bulk request
+type BulkItemResult struct {
+	// Action is index, create, update or delete
+	Action string `json:"action"`
+	Index  string `json:"index"`
+	ID     string `json:"id"`
+	Result string `json:"result,omitempty"`
+	Status int    `json:"status"`
+	// Error is the reason elastic refused the action, empty if it succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// Bulk sends the NDJSON actions like BulkCreate and returns the results of the actions in order.
+// Refused actions don't fail the request, check their Error:
+//
+//	items, err := es.Bulk(datum)
+//	for _, item := range items {
+//		if item.Error != "" {
+//			log.Printf("%s %s/%s failed: %s", item.Action, item.Index, item.ID, item.Error)
+//		}
+//	}
+//
+// With WithSoftDelete the delete actions mark the documents deleted like Delete does.
+// Their Action stays delete, while Result and Error are those of the update: "updated",
+// or document_missing_exception for a missing document.
+func (Es *Client) Bulk(datum []byte, opts ...WriteOption) (items []BulkItemResult, err error) {
+	if len(datum) < 2 {
+		return nil, fmt.Errorf("empty data")
+	}
+
+	ctx, span := Es.startSpan("bulk", "", "")
+	defer func() { endSpan(span, err) }()
+	started := time.Now()
+	body, marked := Es.markBulk(datum, started)
+	var br *bulkResponse
+	defer func() { Es.auditBulk(ctx, started, body, marked, br, err) }()
+
+	br, err = Es.bulk(ctx, body, Es.write(opts))
+	if err != nil {
+		return nil, err
+	}
+	items = make([]BulkItemResult, 0, len(br.Items))
+	for i, item := range br.Items {
+		for action, r := range item {
+			if marked[i] {
+				action = "delete"
+			}
+			res := BulkItemResult{Action: action, Index: r.Index, ID: r.ID, Result: r.Result, Status: r.Status}
+			if r.Error != nil {
+				res.Error = r.Error.Error()
+			}
+			items = append(items, res)
+		}
+	}
+	return items, nil
+}
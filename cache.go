@@ -0,0 +1,352 @@
+package escrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheBackend stores cached documents by key. LRUCache is the in-memory one,
+// implement it over redis or memcached to share the cache between instances.
+type CacheBackend interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// CachedClient serves Read and Source from the cache and reads through to the client on a miss.
+// Writes to a document through it invalidate the cached document. Writes bypassing it,
+// like those of other instances or of a Counter, are seen when the entries expire.
+//
+//	es := escrud.NewCachedClient(client, escrud.NewLRUCache(10000, time.Minute))
+type CachedClient struct {
+	Interface
+	backend CacheBackend
+	*cacheState
+}
+
+// cacheState is shared by the copies of a CachedClient bound to contexts
+type cacheState struct {
+	// mu orders the invalidations and the stores of read results, gens are bumped by
+	// Invalidate so a read which raced with a write doesn't store the stale document
+	mu   sync.Mutex
+	gens [256]uint64
+
+	hits          int64
+	misses        int64
+	invalidations int64
+}
+
+// CacheStats describes the work of a CachedClient
+type CacheStats struct {
+	Hits          int64
+	Misses        int64
+	Invalidations int64
+}
+
+// HitRatio returns the share of reads served from the cache
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// NewCachedClient wraps the client with the cache
+func NewCachedClient(client Interface, backend CacheBackend) *CachedClient {
+	return &CachedClient{Interface: client, backend: backend, cacheState: &cacheState{}}
+}
+
+// WithContext returns a copy bound to ctx by WithContext of the wrapped client,
+// it shares the cache and the stats with c
+//
+//	es.WithContext(r.Context()).Read("article", id)
+func (c *CachedClient) WithContext(ctx context.Context) Interface {
+	return &CachedClient{Interface: c.Interface.WithContext(ctx), backend: c.backend, cacheState: c.cacheState}
+}
+
+var _ Interface = (*CachedClient)(nil)
+
+// Stats returns the cache stats
+func (c *CachedClient) Stats() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Invalidations: atomic.LoadInt64(&c.invalidations),
+	}
+}
+
+func readKey(index, id string) string {
+	return "read\x00" + index + "\x00" + id
+}
+
+func sourceKey(index, id string) string {
+	return "source\x00" + index + "\x00" + id
+}
+
+// Invalidate drops the cached document, call it after writing it bypassing the CachedClient
+func (c *CachedClient) Invalidate(index, id string) {
+	atomic.AddInt64(&c.invalidations, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[genSlot(index, id)]++
+	c.backend.Delete(readKey(index, id))
+	c.backend.Delete(sourceKey(index, id))
+}
+
+// genSlot returns the generation slot of the document, documents may share one
+func genSlot(index, id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(index + "\x00" + id))
+	return int(h.Sum32() % 256)
+}
+
+// gen returns the generation of the document before reading it
+func (c *CachedClient) gen(index, id string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gens[genSlot(index, id)]
+}
+
+// store caches the value read at the generation unless the document was invalidated since
+func (c *CachedClient) store(index, id, key string, value []byte, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[genSlot(index, id)] == gen {
+		c.backend.Set(key, value)
+	}
+}
+
+// Read returns the cached document or reads it. Errors aren't cached.
+func (c *CachedClient) Read(index, id string) (*ResponseBody, error) {
+	key := readKey(index, id)
+	if cached, ok := c.backend.Get(key); ok {
+		var rb ResponseBody
+		if err := json.Unmarshal(cached, &rb); err == nil {
+			atomic.AddInt64(&c.hits, 1)
+			return &rb, nil
+		}
+		c.backend.Delete(key)
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	gen := c.gen(index, id)
+	rb, err := c.Interface.Read(index, id)
+	if err != nil {
+		return rb, err
+	}
+	if b, err := json.Marshal(rb); err == nil {
+		c.store(index, id, key, b, gen)
+	}
+	return rb, nil
+}
+
+// Source returns the cached source of the document or reads it. Errors aren't cached.
+func (c *CachedClient) Source(index, id string) ([]byte, error) {
+	key := sourceKey(index, id)
+	if cached, ok := c.backend.Get(key); ok {
+		atomic.AddInt64(&c.hits, 1)
+		// the caller may change the slice
+		return append([]byte(nil), cached...), nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	gen := c.gen(index, id)
+	src, err := c.Interface.Source(index, id)
+	if err != nil {
+		return src, err
+	}
+	c.store(index, id, key, append([]byte(nil), src...), gen)
+	return src, nil
+}
+
+// Create indexes a new document
+func (c *CachedClient) Create(index string, id string, data []byte, opts ...WriteOption) error {
+	defer c.Invalidate(index, id)
+	return c.Interface.Create(index, id, data, opts...)
+}
+
+// Index indexes the document, replacing the cached one
+func (c *CachedClient) Index(index string, id string, data []byte, opts ...WriteOption) (*ResponseBody, error) {
+	if id != "" {
+		defer c.Invalidate(index, id)
+	}
+	return c.Interface.Index(index, id, data, opts...)
+}
+
+// Update updates the document and drops it from the cache
+func (c *CachedClient) Update(index, id string, data []byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, id)
+	return c.Interface.Update(index, id, data, opts...)
+}
+
+// UpdateDiff see Client.UpdateDiff, it reads the stored source bypassing the cache
+func (c *CachedClient) UpdateDiff(index, id string, v interface{}, opts ...WriteOption) (*ResponseBody, []Change, error) {
+	defer c.Invalidate(index, id)
+	return c.Interface.UpdateDiff(index, id, v, opts...)
+}
+
+// Delete deletes the document and drops it from the cache
+func (c *CachedClient) Delete(index, id string, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, id)
+	return c.Interface.Delete(index, id, opts...)
+}
+
+// Restore see Client.Restore
+func (c *CachedClient) Restore(index, id string, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, id)
+	return c.Interface.Restore(index, id, opts...)
+}
+
+// BulkCreate indexes the documents and drops those with ids in the action lines from the cache
+func (c *CachedClient) BulkCreate(datum []byte, opts ...WriteOption) error {
+	defer c.invalidateBulk(datum)
+	return c.Interface.BulkCreate(datum, opts...)
+}
+
+// Bulk see Client.Bulk, the documents are dropped from the cache as by BulkCreate
+func (c *CachedClient) Bulk(datum []byte, opts ...WriteOption) ([]BulkItemResult, error) {
+	defer c.invalidateBulk(datum)
+	return c.Interface.Bulk(datum, opts...)
+}
+
+// invalidateBulk drops the documents with ids in the action lines of the bulk request
+func (c *CachedClient) invalidateBulk(datum []byte) {
+	for _, line := range bytes.Split(datum, []byte("\n")) {
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if json.Unmarshal(line, &action) != nil {
+			continue
+		}
+		for _, meta := range action {
+			if meta.ID != "" {
+				c.Invalidate(meta.Index, meta.ID)
+			}
+		}
+	}
+}
+
+// IncrementField see Client.IncrementField
+func (c *CachedClient) IncrementField(index string, docID string, fieldName string, incr int, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.IncrementField(index, docID, fieldName, incr, opts...)
+}
+
+// InsertArrayItem see Client.InsertArrayItem
+func (c *CachedClient) InsertArrayItem(index string, docID string, arrayName string, elem []byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.InsertArrayItem(index, docID, arrayName, elem, opts...)
+}
+
+// UpdateArrayItem see Client.UpdateArrayItem
+func (c *CachedClient) UpdateArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, subst []byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.UpdateArrayItem(index, docID, arrayName, itemName, itemValue, subst, opts...)
+}
+
+// RemoveArrayItem see Client.RemoveArrayItem
+func (c *CachedClient) RemoveArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.RemoveArrayItem(index, docID, arrayName, itemName, itemValue, opts...)
+}
+
+// SortArrayItems see Client.SortArrayItems
+func (c *CachedClient) SortArrayItems(index string, docID string, arrayName string, itemName string, desc bool, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.SortArrayItems(index, docID, arrayName, itemName, desc, opts...)
+}
+
+// MoveArrayItem see Client.MoveArrayItem
+func (c *CachedClient) MoveArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, position int, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.MoveArrayItem(index, docID, arrayName, itemName, itemValue, position, opts...)
+}
+
+// InsertArrayItemAt see Client.InsertArrayItemAt
+func (c *CachedClient) InsertArrayItemAt(index string, docID string, arrayName string, position int, elem []byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.InsertArrayItemAt(index, docID, arrayName, position, elem, opts...)
+}
+
+// InsertArrayItemIfAbsent see Client.InsertArrayItemIfAbsent
+func (c *CachedClient) InsertArrayItemIfAbsent(index string, docID string, arrayName string, itemName string, elem []byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.InsertArrayItemIfAbsent(index, docID, arrayName, itemName, elem, opts...)
+}
+
+// InsertArrayItems see Client.InsertArrayItems
+func (c *CachedClient) InsertArrayItems(index string, docID string, arrayName string, elems [][]byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.InsertArrayItems(index, docID, arrayName, elems, opts...)
+}
+
+// CapArray see Client.CapArray
+func (c *CachedClient) CapArray(index string, docID string, arrayName string, max int, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.CapArray(index, docID, arrayName, max, opts...)
+}
+
+// PatchArrayItems see Client.PatchArrayItems
+func (c *CachedClient) PatchArrayItems(index string, docID string, arrayName string, match map[string]interface{}, patch []byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.PatchArrayItems(index, docID, arrayName, match, patch, opts...)
+}
+
+// DecrementField see Client.DecrementField
+func (c *CachedClient) DecrementField(index string, docID string, fieldName string, decr int, floor int, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.DecrementField(index, docID, fieldName, decr, floor, opts...)
+}
+
+// IncrementFloatField see Client.IncrementFloatField
+func (c *CachedClient) IncrementFloatField(index string, docID string, fieldName string, incr float64, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.IncrementFloatField(index, docID, fieldName, incr, opts...)
+}
+
+// SetFieldMax see Client.SetFieldMax
+func (c *CachedClient) SetFieldMax(index string, docID string, fieldName string, value float64, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.SetFieldMax(index, docID, fieldName, value, opts...)
+}
+
+// SetFieldMin see Client.SetFieldMin
+func (c *CachedClient) SetFieldMin(index string, docID string, fieldName string, value float64, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.SetFieldMin(index, docID, fieldName, value, opts...)
+}
+
+// SetFieldIfAbsent see Client.SetFieldIfAbsent
+func (c *CachedClient) SetFieldIfAbsent(index string, docID string, fieldName string, value []byte, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.SetFieldIfAbsent(index, docID, fieldName, value, opts...)
+}
+
+// ToggleField see Client.ToggleField
+func (c *CachedClient) ToggleField(index string, docID string, fieldName string, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.ToggleField(index, docID, fieldName, opts...)
+}
+
+// RemoveField see Client.RemoveField
+func (c *CachedClient) RemoveField(index string, docID string, fieldName string, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.RemoveField(index, docID, fieldName, opts...)
+}
+
+// RenameField see Client.RenameField
+func (c *CachedClient) RenameField(index string, docID string, fieldName string, newName string, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.RenameField(index, docID, fieldName, newName, opts...)
+}
+
+// AppendToStringList see Client.AppendToStringList
+func (c *CachedClient) AppendToStringList(index string, docID string, fieldName string, value string, unique bool, opts ...WriteOption) (*ResponseBody, error) {
+	defer c.Invalidate(index, docID)
+	return c.Interface.AppendToStringList(index, docID, fieldName, value, unique, opts...)
+}
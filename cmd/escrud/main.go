@@ -0,0 +1,275 @@
+// Command escrud runs escrud operations from the command line:
+//
+//	escrud -host localhost -port 9200 get article 123
+//	escrud source article 123
+//	escrud exists article 123
+//	escrud create article 123 '{"title": "..."}'
+//	escrud update article 123 '{"title": "..."}'
+//	escrud delete article 123
+//	escrud increment article 123 viewed 1
+//	escrud array-insert mask _3 mask_articles '{"article_id": 1, "position": 1}'
+//	escrud array-update mask _3 mask_articles article_id 1 '{"article_id": 1, "position": 2}'
+//	escrud array-remove mask _3 mask_articles article_id 1
+//	escrud bulk entries.ndjson
+//
+// JSON arguments and bulk files may be "-" to read stdin. The responses of elastic are printed
+// as indented JSON, -raw prints them as elastic returns them. exists prints true or false,
+// bulk the number of lines sent. delete and the delete actions of bulk remove the documents,
+// with -soft-delete they mark them deleted instead.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/RGRU/escrud"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "escrud:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// command is a subcommand with the names of its arguments
+type command struct {
+	args []string
+	run  func(es escrud.Interface, args []string, stdin io.Reader) (interface{}, error)
+}
+
+// summaries print the result of the command instead of the response of elastic
+var summaries = map[string]bool{"exists": true, "bulk": true}
+
+var commands = map[string]command{
+	"get": {[]string{"index", "id"}, func(es escrud.Interface, a []string, _ io.Reader) (interface{}, error) {
+		return es.Read(a[0], a[1])
+	}},
+
+	"source": {[]string{"index", "id"}, func(es escrud.Interface, a []string, _ io.Reader) (interface{}, error) {
+		src, err := es.Source(a[0], a[1])
+		return json.RawMessage(src), err
+	}},
+
+	"exists": {[]string{"index", "id"}, func(es escrud.Interface, a []string, _ io.Reader) (interface{}, error) {
+		return es.Exists(a[0], a[1])
+	}},
+
+	"create": {[]string{"index", "id", "json"}, func(es escrud.Interface, a []string, stdin io.Reader) (interface{}, error) {
+		data, err := readArg(a[2], stdin)
+		if err != nil {
+			return nil, err
+		}
+		return nil, es.Create(a[0], a[1], data)
+	}},
+
+	"update": {[]string{"index", "id", "json"}, func(es escrud.Interface, a []string, stdin io.Reader) (interface{}, error) {
+		data, err := readArg(a[2], stdin)
+		if err != nil {
+			return nil, err
+		}
+		return es.Update(a[0], a[1], data)
+	}},
+
+	"delete": {[]string{"index", "id"}, func(es escrud.Interface, a []string, _ io.Reader) (interface{}, error) {
+		return es.Delete(a[0], a[1])
+	}},
+
+	"increment": {[]string{"index", "id", "field", "n"}, func(es escrud.Interface, a []string, _ io.Reader) (interface{}, error) {
+		n, err := strconv.Atoi(a[3])
+		if err != nil {
+			return nil, fmt.Errorf("bad increment %q: %v", a[3], err)
+		}
+		return es.IncrementField(a[0], a[1], a[2], n)
+	}},
+
+	"array-insert": {[]string{"index", "id", "array", "json"}, func(es escrud.Interface, a []string, stdin io.Reader) (interface{}, error) {
+		elem, err := readArg(a[3], stdin)
+		if err != nil {
+			return nil, err
+		}
+		return es.InsertArrayItem(a[0], a[1], a[2], elem)
+	}},
+
+	"array-update": {[]string{"index", "id", "array", "item", "value", "json"}, func(es escrud.Interface, a []string, stdin io.Reader) (interface{}, error) {
+		value, err := strconv.Atoi(a[4])
+		if err != nil {
+			return nil, fmt.Errorf("bad item value %q: %v", a[4], err)
+		}
+		subst, err := readArg(a[5], stdin)
+		if err != nil {
+			return nil, err
+		}
+		return es.UpdateArrayItem(a[0], a[1], a[2], a[3], value, subst)
+	}},
+
+	"array-remove": {[]string{"index", "id", "array", "item", "value"}, func(es escrud.Interface, a []string, _ io.Reader) (interface{}, error) {
+		value, err := strconv.Atoi(a[4])
+		if err != nil {
+			return nil, fmt.Errorf("bad item value %q: %v", a[4], err)
+		}
+		return es.RemoveArrayItem(a[0], a[1], a[2], a[3], value)
+	}},
+
+	"bulk": {[]string{"file"}, func(es escrud.Interface, a []string, stdin io.Reader) (interface{}, error) {
+		var data []byte
+		var err error
+		if a[0] == "-" {
+			data, err = ioutil.ReadAll(stdin)
+		} else {
+			data, err = ioutil.ReadFile(a[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read entries: %v", err)
+		}
+		// the bulk API wants the last line terminated too
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		items, err := es.Bulk(data)
+		if err != nil {
+			return nil, err
+		}
+		var failed []escrud.BulkItemResult
+		for _, item := range items {
+			if item.Error != "" {
+				failed = append(failed, item)
+			}
+		}
+		if len(failed) > 0 {
+			first := failed[0]
+			return nil, fmt.Errorf("%d of %d actions failed, first: %s %s/%s: %s",
+				len(failed), len(items), first.Action, first.Index, first.ID, first.Error)
+		}
+		return map[string]interface{}{"result": "ok", "lines": bytes.Count(data, []byte("\n"))}, nil
+	}},
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("escrud", flag.ContinueOnError)
+	host := fs.String("host", "localhost", "elastic host")
+	port := fs.Int("port", 9200, "elastic port")
+	scheme := fs.String("scheme", "http", "elastic scheme, http or https")
+	raw := fs.Bool("raw", false, "print JSON as is, not indented")
+	soft := fs.Bool("soft-delete", false, "mark documents deleted by delete and bulk instead of deleting them, see escrud.WithSoftDelete")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: escrud [flags] command args...")
+		fmt.Fprintln(fs.Output(), "\ncommands:")
+		for _, name := range []string{"get", "source", "exists", "create", "update", "delete", "increment",
+			"array-insert", "array-update", "array-remove", "bulk"} {
+			fmt.Fprintf(fs.Output(), "  %s", name)
+			for _, a := range commands[name].args {
+				fmt.Fprintf(fs.Output(), " %s", a)
+			}
+			fmt.Fprintln(fs.Output())
+		}
+		fmt.Fprintln(fs.Output(), "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+	cmdArgs := fs.Args()[1:]
+	if len(cmdArgs) != len(cmd.args) {
+		return fmt.Errorf("%s wants %d arguments: %v", fs.Arg(0), len(cmd.args), cmd.args)
+	}
+
+	var last []byte
+	opts := []escrud.Option{escrud.WithInterceptors(keepBody(&last))}
+	if *soft {
+		opts = append(opts, escrud.WithSoftDelete())
+	}
+	es, err := escrud.Connect(*host, *port, *scheme, opts...)
+	if err != nil {
+		return fmt.Errorf("cannot connect: %v", err)
+	}
+
+	res, err := cmd.run(es, cmdArgs, stdin)
+	if err != nil {
+		return err
+	}
+	if !summaries[fs.Arg(0)] && json.Valid(last) {
+		return outputResponse(stdout, last, *raw)
+	}
+	return output(stdout, res, *raw)
+}
+
+// keepBody stores the body of the last response to last
+func keepBody(last *[]byte) escrud.Interceptor {
+	return escrud.InterceptorFunc(func(call *escrud.Call, next escrud.Handler) (*http.Response, error) {
+		res, err := next(call)
+		if err != nil || res.Body == nil {
+			return res, err
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read response body: %v", err)
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		*last = body
+		return res, nil
+	})
+}
+
+// readArg returns the JSON argument, stdin for "-"
+func readArg(arg string, stdin io.Reader) ([]byte, error) {
+	data := []byte(arg)
+	if arg == "-" {
+		var err error
+		if data, err = ioutil.ReadAll(stdin); err != nil {
+			return nil, fmt.Errorf("cannot read stdin: %v", err)
+		}
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("bad json: %s", data)
+	}
+	return data, nil
+}
+
+// outputResponse prints the response of elastic, as is with raw
+func outputResponse(w io.Writer, body []byte, raw bool) error {
+	out := bytes.TrimSpace(body)
+	if !raw {
+		var compact, buf bytes.Buffer
+		if json.Compact(&compact, out) == nil && json.Indent(&buf, compact.Bytes(), "", "  ") == nil {
+			out = buf.Bytes()
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func output(w io.Writer, v interface{}, raw bool) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot encode result: %v", err)
+	}
+	if !raw {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, out, "", "  "); err == nil {
+			out = buf.Bytes()
+		}
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/RGRU/escrud/escrudtest"
+)
+
+func TestRun(t *testing.T) {
+	srv := escrudtest.NewServer()
+	defer srv.Close()
+	addr := []string{"-host", srv.Host(), "-port", strconv.Itoa(srv.Port())}
+
+	steps := []struct {
+		args  []string
+		stdin string
+		want  string
+	}{
+		{[]string{"create", "article", "1", `{"viewed": 1}`}, "", `"result": "created"`},
+		{[]string{"increment", "article", "1", "viewed", "2"}, "", `"result": "updated"`},
+		{[]string{"array-insert", "article", "1", "mask_articles", "-"}, `{"article_id": 5, "position": 1}`, `"result": "updated"`},
+		{[]string{"-raw", "source", "article", "1"}, "", `{"mask_articles":[{"article_id":5,"position":1}],"viewed":3}`},
+		{[]string{"-raw", "get", "article", "1"}, "", `"found":true`},
+		{[]string{"create", "article", "4", `{"viewed": 4}`}, "", `"_shards": {`},
+		{[]string{"bulk", "-"}, `{"index": {"_index": "article", "_id": "2"}}` + "\n" + `{"viewed": 7}`, `"lines": 2`},
+		{[]string{"exists", "article", "2"}, "", "true"},
+		{[]string{"delete", "article", "2"}, "", `"result": "deleted"`},
+		{[]string{"exists", "article", "2"}, "", "false"},
+		{[]string{"-soft-delete", "delete", "article", "4"}, "", `"result": "updated"`},
+		{[]string{"-soft-delete", "bulk", "-"}, `{"delete": {"_index": "article", "_id": "1"}}`, `"lines": 1`},
+		{[]string{"-soft-delete", "exists", "article", "1"}, "", "false"},
+		{[]string{"-raw", "source", "article", "1"}, "", `"deleted":true`},
+	}
+	for _, s := range steps {
+		var out bytes.Buffer
+		if err := run(append(addr, s.args...), strings.NewReader(s.stdin), &out); err != nil {
+			t.Fatalf("%v failed: %v", s.args, err)
+		}
+		if !strings.Contains(out.String(), s.want) {
+			t.Errorf("%v should print %s, got %s", s.args, s.want, out.String())
+		}
+	}
+
+	if err := run(append(addr, "get", "article"), nil, &bytes.Buffer{}); err == nil {
+		t.Errorf("should fail on missing arguments")
+	}
+	if err := run(append(addr, "create", "article", "3", "{bad"), nil, &bytes.Buffer{}); err == nil {
+		t.Errorf("should fail on bad json")
+	}
+	conflict := `{"create": {"_index": "article", "_id": "1"}}` + "\n" + `{"viewed": 9}` + "\n" +
+		`{"index": {"_index": "article", "_id": "3"}}` + "\n" + `{"viewed": 3}`
+	err := run(append(addr, "bulk", "-"), strings.NewReader(conflict), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 actions failed") || !strings.Contains(err.Error(), "article/1") {
+		t.Errorf("should fail on the refused action, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package escrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Doc is a document passed through Copy
+type Doc struct {
+	// ID is the id of the document, elastic generates it for an empty one
+	ID     string
+	Source json.RawMessage
+}
+
+// Transform turns a source document into the documents to write to the target index:
+// none filters it out, several split it. An error skips the document and is reported
+// by OnError and counted in Progress.Failed.
+type Transform func(doc Doc) ([]Doc, error)
+
+// Workers sets the number of goroutines which transform and write batches of Copy, 1 by default
+func Workers(n int) TransferOption {
+	return func(t *transfer) {
+		t.workers = n
+	}
+}
+
+// RateLimit limits the documents written by Copy to perSecond, not limited by default
+func RateLimit(perSecond float64) TransferOption {
+	return func(t *transfer) {
+		t.rate = perSecond
+	}
+}
+
+// OnError calls fn for each document of Copy which the transformation failed on or elastic refused
+func OnError(fn func(id string, err error)) TransferOption {
+	return func(t *transfer) {
+		t.onError = fn
+	}
+}
+
+// limiter spaces out writes to keep the rate of documents
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(perSecond float64) *limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until n more documents may be written
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(n) * l.interval)
+	l.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// Copy streams the documents of the src index through fn and writes the result to the dst index
+// by bulk requests, overwriting documents with the same ids. Use it for the changes _reindex
+// can't do with a painless script:
+//
+//	p, err := es.Copy("article", "article_v2", func(doc escrud.Doc) ([]escrud.Doc, error) {
+//		var a Article
+//		if err := json.Unmarshal(doc.Source, &a); err != nil {
+//			return nil, err
+//		}
+//		if a.Deleted {
+//			return nil, nil
+//		}
+//		a.Slug = slugify(a.Title)
+//		src, err := json.Marshal(a)
+//		return []escrud.Doc{{ID: doc.ID, Source: src}}, err
+//	}, escrud.Workers(4), escrud.RateLimit(2000))
+//
+// Batches are read in order of _id or the Tiebreak field and transformed and written concurrently by Workers,
+// fn must be safe for concurrent use then. Failed documents don't stop the copy,
+// the error after it tells their number. A failed search or bulk request stops it.
+// Progress.Total counts all the matching documents, beyond the 10000 elastic counts by default.
+func (Es *Client) Copy(src, dst string, fn Transform, opts ...TransferOption) (p Progress, err error) {
+	ctx, span := Es.startSpan("copy", src, "")
+	defer func() { endSpan(span, err) }()
+
+	t := newTransfer(opts)
+	lim := newLimiter(t.rate)
+
+	var (
+		mu       sync.Mutex // guards p and firstErr
+		firstErr error
+		once     sync.Once
+		fatal    error
+		wg       sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	fail := func(err error) {
+		once.Do(func() {
+			fatal = err
+			close(stop)
+		})
+	}
+	failed := func(id string, err error) {
+		if t.onError != nil {
+			t.onError(id, err)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		p.Failed++
+	}
+
+	batches := make(chan []Hit)
+	for i := 0; i < t.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hits := range batches {
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+				if err := Es.copyBatch(ctx, dst, fn, hits, t, lim, &mu, &p, failed); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	scanErr := Es.scan(ctx, src, t, func(hits []Hit, total int64, _ string) error {
+		mu.Lock()
+		p.Total = total
+		mu.Unlock()
+		select {
+		case batches <- hits:
+			return nil
+		case <-stop:
+			return errStopped
+		}
+	})
+	close(batches)
+	wg.Wait()
+
+	if scanErr != nil && scanErr != errStopped {
+		return p, scanErr
+	}
+	if fatal != nil {
+		return p, fatal
+	}
+	if firstErr != nil {
+		return p, fmt.Errorf("%d documents failed, first: %v", p.Failed, firstErr)
+	}
+	return p, nil
+}
+
+// errStopped stops the scan of Copy after a failed worker
+var errStopped = errors.New("copy stopped")
+
+// docError is a failure of a source document
+type docError struct {
+	id  string
+	err error
+}
+
+// copyBatch transforms the hits and writes the result to the index
+func (Es *Client) copyBatch(ctx context.Context, index string, fn Transform, hits []Hit, t *transfer, lim *limiter,
+	mu *sync.Mutex, p *Progress, failed func(id string, err error)) error {
+	var (
+		body    bytes.Buffer
+		n       int
+		dropped int64
+		errs    []docError
+	)
+	for _, hit := range hits {
+		docs, err := fn(Doc{ID: hit.ID, Source: hit.Source})
+		if err != nil {
+			errs = append(errs, docError{hit.ID, fmt.Errorf("cannot transform %s/%s: %v", hit.Index, hit.ID, err)})
+			continue
+		}
+		if len(docs) == 0 {
+			dropped++
+			continue
+		}
+		for _, doc := range docs {
+			meta := map[string]interface{}{"_index": index}
+			if doc.ID != "" {
+				meta["_id"] = doc.ID
+			}
+			action, _ := json.Marshal(map[string]interface{}{"index": meta})
+			source, err := compactJSON(doc.Source)
+			if err != nil {
+				errs = append(errs, docError{hit.ID, fmt.Errorf("bad json of %s transformed from %s: %v", doc.ID, hit.ID, err)})
+				continue
+			}
+			body.Write(action)
+			body.WriteByte('\n')
+			body.Write(source)
+			body.WriteByte('\n')
+			n++
+		}
+	}
+
+	var res *bulkResponse
+	if n > 0 {
+		lim.wait(n)
+		var err error
+		if res, err = Es.bulk(ctx, body.Bytes(), Es.write(t.write)); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range errs {
+		failed(e.id, e.err)
+	}
+	if res != nil {
+		for _, item := range res.Items {
+			r := item.result()
+			if r.Error != nil {
+				failed(r.ID, fmt.Errorf("cannot copy to %s/%s: %v", r.Index, r.ID, r.Error))
+				continue
+			}
+			p.Written++
+		}
+	}
+	p.Docs += int64(len(hits))
+	p.Dropped += dropped
+	t.progress(*p)
+	return nil
+}
+
+// compactJSON makes the document fit a line of a bulk request
+func compactJSON(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, src); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
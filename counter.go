@@ -0,0 +1,277 @@
+package escrud
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// counterScript adds params.fields to the fields of the document, missing fields count from zero
+const counterScript = `for (def e : params.fields.entrySet()) { def v = ctx._source[e.getKey()]; ctx._source[e.getKey()] = (v == null ? 0 : v) + e.getValue(); }`
+
+// Counter accumulates increments of numeric fields in memory and writes them
+// to elastic periodically by bulk scripted updates, one per document.
+// Use it instead of IncrementField for hot counters like views:
+//
+//	views := es.NewCounter(time.Minute)
+//	defer views.Close()
+//	views.Add("article", id, "viewed", 1)
+type Counter struct {
+	es              *Client
+	flushSize       int
+	retryOnConflict int
+
+	mu      sync.Mutex
+	pending map[counterKey]map[string]int64
+	stats   CounterStats
+	closed  bool
+
+	flushMu sync.Mutex
+	errs    chan error
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type counterKey struct {
+	index string
+	id    string
+}
+
+// CounterStats describes the work of a Counter
+type CounterStats struct {
+	// Increments is the number of Add calls
+	Increments int64
+	// Pending is the number of documents waiting for the flush
+	Pending int
+	// Flushes is the number of bulk requests sent
+	Flushes int64
+	// Updates is the number of documents updated successfully
+	Updates int64
+	// Failed is the number of documents which could not be updated, their increments are lost
+	Failed int64
+	// Rejected is the number of Add calls after Close, their increments are lost
+	Rejected  int64
+	LastFlush time.Time
+	LastError error
+}
+
+// CounterOption configures a Counter
+type CounterOption func(*Counter)
+
+// FlushSize sets the number of pending documents which makes the Counter flush before the interval ends,
+// it's also the maximum number of documents in a bulk request. 1000 by default.
+func FlushSize(n int) CounterOption {
+	return func(c *Counter) {
+		c.flushSize = n
+	}
+}
+
+// RetryOnConflict sets how many times elastic retries an update on version conflict. 3 by default.
+func RetryOnConflict(n int) CounterOption {
+	return func(c *Counter) {
+		c.retryOnConflict = n
+	}
+}
+
+// NewCounter starts a Counter which flushes every interval. With zero interval it flushes
+// only on FlushSize and explicit Flush calls. Close it to write the rest.
+func (Es *Client) NewCounter(interval time.Duration, opts ...CounterOption) *Counter {
+	c := &Counter{
+		es:              Es,
+		flushSize:       1000,
+		retryOnConflict: 3,
+		pending:         map[counterKey]map[string]int64{},
+		errs:            make(chan error, 16),
+		kick:            make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	go c.loop(interval)
+	return c
+}
+
+// Add increments the field of the document by n. Increments added after Close are rejected,
+// they are counted in CounterStats.Rejected only.
+func (c *Counter) Add(index, id, field string, n int) {
+	c.mu.Lock()
+	if c.closed {
+		c.stats.Rejected++
+		c.mu.Unlock()
+		return
+	}
+	key := counterKey{index, id}
+	fields, ok := c.pending[key]
+	if !ok {
+		fields = map[string]int64{}
+		c.pending[key] = fields
+	}
+	fields[field] += int64(n)
+	c.stats.Increments++
+	full := len(c.pending) >= c.flushSize
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Errors returns errors of the background flushes. They are dropped if nobody reads them.
+func (c *Counter) Errors() <-chan error {
+	return c.errs
+}
+
+// Stats returns a snapshot of the Counter stats
+func (c *Counter) Stats() CounterStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Pending = len(c.pending)
+	return s
+}
+
+// Close stops the background flushes and writes the pending increments. Next calls do nothing.
+func (c *Counter) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.done
+	return c.Flush()
+}
+
+func (c *Counter) loop(interval time.Duration) {
+	defer close(c.done)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-c.kick:
+		case <-c.stop:
+			return
+		}
+		if err := c.Flush(); err != nil {
+			select {
+			case c.errs <- err:
+			default:
+			}
+		}
+	}
+}
+
+// Flush writes the pending increments now. Increments of a failed request are kept for the next flush,
+// those of the documents elastic failed to update are dropped and counted in CounterStats.Failed.
+func (c *Counter) Flush() (err error) {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = map[counterKey]map[string]int64{}
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	ctx, span := c.es.startSpan("counter_flush", "", "")
+	defer func() { endSpan(span, err) }()
+
+	keys := make([]counterKey, 0, len(pending))
+	for k := range pending {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].index != keys[j].index {
+			return keys[i].index < keys[j].index
+		}
+		return keys[i].id < keys[j].id
+	})
+
+	for len(keys) > 0 {
+		n := c.flushSize
+		if n <= 0 || n > len(keys) {
+			n = len(keys)
+		}
+		batch := keys[:n]
+		keys = keys[n:]
+
+		var body []byte
+		for _, k := range batch {
+			action, _ := json.Marshal(map[string]interface{}{
+				"update": map[string]interface{}{"_index": k.index, "_id": k.id, "retry_on_conflict": c.retryOnConflict},
+			})
+			update, _ := json.Marshal(map[string]interface{}{
+				"script": map[string]interface{}{
+					"source": counterScript,
+					"lang":   "painless",
+					"params": map[string]interface{}{"fields": pending[k]},
+				},
+			})
+			body = append(body, action...)
+			body = append(body, '\n')
+			body = append(body, update...)
+			body = append(body, '\n')
+		}
+
+		res, bulkErr := c.es.bulk(ctx, body, c.es.write(nil))
+		c.mu.Lock()
+		c.stats.Flushes++
+		c.stats.LastFlush = time.Now()
+		if bulkErr != nil {
+			// keep the increments for the next flush
+			for _, k := range batch {
+				c.merge(k, pending[k])
+			}
+			for _, k := range keys {
+				c.merge(k, pending[k])
+			}
+			c.stats.LastError = bulkErr
+			c.mu.Unlock()
+			return fmt.Errorf("counter flush failed: %v", bulkErr)
+		}
+		for _, item := range res.Items {
+			r := item.result()
+			if r.Error != nil {
+				c.stats.Failed++
+				err = fmt.Errorf("counter flush of %s/%s failed: %v", r.Index, r.ID, r.Error)
+				c.stats.LastError = err
+				continue
+			}
+			c.stats.Updates++
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// merge adds fields to the pending ones of the key, c.mu must be held
+func (c *Counter) merge(key counterKey, fields map[string]int64) {
+	cur, ok := c.pending[key]
+	if !ok {
+		c.pending[key] = fields
+		return
+	}
+	for f, n := range fields {
+		cur[f] += n
+	}
+}
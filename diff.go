@@ -0,0 +1,187 @@
+package escrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change is a changed field of a document. Path is dotted for the fields of nested objects,
+// Old is nil for an added field and New is nil for a removed one. Numbers are json.Number.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Diff returns the partial document which turns the old source into the new one by Update,
+// and the changed fields sorted by path. Objects are compared field by field, arrays and other
+// values as a whole. Removed fields are set to null, as a partial document can't remove them;
+// a null field is the same as a missing one, so a source updated by the partial document
+// has no diff with the new one. Both sources must be JSON objects, an empty partial document means they are equal.
+func Diff(old, new []byte) (partial []byte, changes []Change, err error) {
+	o, err := decodeObject(old)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad old source: %v", err)
+	}
+	n, err := decodeObject(new)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad new source: %v", err)
+	}
+	return diff(o, n)
+}
+
+// diff is Diff of the decoded sources
+func diff(o, n map[string]interface{}) (partial []byte, changes []Change, err error) {
+	doc := diffObjects("", o, n, &changes)
+	if len(doc) == 0 {
+		return nil, nil, nil
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	partial, err = json.Marshal(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encode partial document: %v", err)
+	}
+	return partial, changes, nil
+}
+
+// diffObjects returns the fields of n which differ from o, nested objects are diffed recursively.
+// Null fields are skipped as missing.
+func diffObjects(prefix string, o, n map[string]interface{}, changes *[]Change) map[string]interface{} {
+	doc := map[string]interface{}{}
+	for k, nv := range n {
+		if nv == nil {
+			continue
+		}
+		path := prefix + k
+		ov := o[k]
+		if ov == nil {
+			doc[k] = nv
+			*changes = append(*changes, Change{Path: path, New: nv})
+			continue
+		}
+		om, oIsObj := ov.(map[string]interface{})
+		nm, nIsObj := nv.(map[string]interface{})
+		if oIsObj && nIsObj {
+			if sub := diffObjects(path+".", om, nm, changes); len(sub) > 0 {
+				doc[k] = sub
+			}
+			continue
+		}
+		if !reflect.DeepEqual(ov, nv) {
+			doc[k] = nv
+			*changes = append(*changes, Change{Path: path, Old: ov, New: nv})
+		}
+	}
+	for k, ov := range o {
+		if ov != nil && n[k] == nil {
+			doc[k] = nil
+			*changes = append(*changes, Change{Path: prefix + k, Old: ov})
+		}
+	}
+	return doc
+}
+
+// decodeObject decodes a JSON object keeping numbers as written, so 1 and 1.0 differ
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("not an object: %s", data)
+	}
+	return obj, nil
+}
+
+// mergeDiffScript applies the partial document of Diff, removing the fields set to null
+const mergeDiffScript = `
+void merge(Map dst, Map doc) { for (def e : doc.entrySet()) { def v = e.getValue(); if (v == null) { dst.remove(e.getKey()); } else if (v instanceof Map && dst.get(e.getKey()) instanceof Map) { merge(dst.get(e.getKey()), v); } else { dst.put(e.getKey(), v); } } }
+merge(ctx._source, params.doc);
+`
+
+// UpdateDiff updates the document to v, marshalled to JSON, sending only the fields which differ
+// from the stored source. Fields missing from v are removed from the document, by a script then.
+// With WithSoftDelete it fails with ErrDeleted on a marked document and leaves the mark fields alone.
+// If nothing changed it sends no update and returns the "noop" result.
+// The changes are returned for audit logs. A write between the read of the source and the update
+// may be overwritten, v should hold the whole document.
+func (Es *Client) UpdateDiff(index, id string, v interface{}, opts ...WriteOption) (rb *ResponseBody, changes []Change, err error) {
+	ctx, span := Es.startSpan("update_diff", index, id)
+	defer func() { endSpan(span, err) }()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encode document: %v", err)
+	}
+	stored, err := source(ctx, Es.Client, index, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	o, err := decodeObject(stored)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad stored source: %v", err)
+	}
+	n, err := decodeObject(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad document: %v", err)
+	}
+	if err := Es.checkDeleted(index, id, o); err != nil {
+		return nil, nil, err
+	}
+	if Es.softDelete {
+		// the mark is kept, only Delete and Restore change it
+		for _, k := range []string{DeletedField, DeletedAtField} {
+			delete(o, k)
+			delete(n, k)
+		}
+	}
+	partial, changes, err := diff(o, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) == 0 {
+		return &ResponseBody{Index: index, ID: id, Result: "noop"}, nil, nil
+	}
+
+	a := Es.startAudit(ctx, "update_diff", index, id, false)
+	if a != nil {
+		a.rec.Before = stored
+	}
+	if removes(changes) {
+		var body []byte
+		body, err = json.Marshal(map[string]interface{}{
+			"script": map[string]interface{}{
+				"source": mergeDiffScript,
+				"lang":   "painless",
+				"params": map[string]json.RawMessage{"doc": partial},
+			},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot encode script: %v", err)
+		}
+		a.scriptOf(body)
+		rb, err = updateBody(ctx, Es.Client, index, id, body, Es.write(opts))
+	} else {
+		rb, err = update(ctx, Es.Client, index, id, partial, Es.write(opts))
+	}
+	a.finish(partial, rb, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rb, changes, nil
+}
+
+// removes tells if the changes remove a field
+func removes(changes []Change) bool {
+	for _, c := range changes {
+		if c.New == nil {
+			return true
+		}
+	}
+	return false
+}
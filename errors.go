@@ -0,0 +1,45 @@
+package escrud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrAlreadyExists matches *AlreadyExistsError with errors.Is
+var ErrAlreadyExists = errors.New("document already exists")
+
+// ErrBadCursor is returned by Pager.Page for a cursor token it didn't issue
+var ErrBadCursor = errors.New("bad cursor")
+
+// ErrResultWindow is returned by Pager.PageAt for pages beyond index.max_result_window
+var ErrResultWindow = errors.New("page is beyond max_result_window")
+
+// ErrDeleted is returned by Read and Source of a document marked deleted, see WithSoftDelete
+var ErrDeleted = errors.New("document is deleted")
+
+// AlreadyExistsError is returned by Create when there's already a document with such id
+type AlreadyExistsError struct {
+	Index  string
+	ID     string
+	Reason string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("document %s/%s already exists: %s", e.Index, e.ID, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrAlreadyExists) work
+func (e *AlreadyExistsError) Is(target error) bool {
+	return target == ErrAlreadyExists
+}
+
+func newAlreadyExistsError(index, id string, resp []byte) *AlreadyExistsError {
+	var body struct {
+		Error struct {
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	json.Unmarshal(resp, &body)
+	return &AlreadyExistsError{Index: index, ID: id, Reason: body.Error.Reason}
+}
@@ -3,13 +3,17 @@ package escrud
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ResponseBody struct from elastic, common for both success and fail answers
@@ -27,105 +31,219 @@ type ResponseBody struct {
 type Client struct {
 	Client *elasticsearch.Client
 	Info   *esapi.Response
+
+	ctx            context.Context
+	tracerProvider trace.TracerProvider
+	logger         Logger
+	dumpBodies     bool
+	transport      http.RoundTripper
+	writeOptions   WriteOptions
+	flight         *flightGroup
+	audit          AuditSink
+	softDelete     bool
+	interceptors   []Interceptor
+}
+
+// Option configures the Client created by Connect
+type Option func(*Client)
+
+// WithTransport replaces the fasthttp Transport used by Connect,
+// e.g. with the escrudtest.Recorder
+func WithTransport(t http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = t
+	}
 }
 
 // Connect to a elastic
-func Connect(host string, port int, scheme string) (*Client, error) {
+func Connect(host string, port int, scheme string, opts ...Option) (*Client, error) {
 	var err error
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
 	//Es, err = elasticsearch.NewDefaultClient()
 	esServer := fmt.Sprintf("%s://%s:%d", scheme, host, port)
 	cfg := elasticsearch.Config{
 		Addresses: []string{esServer},
-		Transport: &Transport{}, // https://github.com/elastic/go-elasticsearch/blob/master/_examples/fasthttp/fasthttp.go
+		Transport: &Transport{TracerProvider: c.tracerProvider}, // https://github.com/elastic/go-elasticsearch/blob/master/_examples/fasthttp/fasthttp.go
+	}
+	if c.transport != nil {
+		cfg.Transport = c.transport
+	}
+	if len(c.interceptors) > 0 {
+		cfg.Transport = &interceptTransport{next: cfg.Transport, chain: c.interceptors}
+	}
+	if c.logger != nil {
+		cfg.Logger = &roundTripLogger{logger: c.logger, dumpBodies: c.dumpBodies}
 	}
 	es, err := elasticsearch.NewClient(cfg)
 	if err != nil {
-		log.Fatalf("Error creating the client: %s\n", err)
+		c.log().Error("cannot create elasticsearch client", "error", err)
 		return nil, err
 	}
-	log.Println("Elasticsearch version:", elasticsearch.Version)
+	c.log().Info("elasticsearch client", "version", elasticsearch.Version)
 	info, err := es.Info()
 	if err != nil {
-		log.Printf("Cannot get server info: %s\n", err)
-		log.Printf("You should check Elastic health!")
+		c.log().Error("cannot get server info, you should check Elastic health", "error", err)
 		return nil, err
 	}
-	return &Client{
-		Client: es,
-		Info:   info,
-	}, nil
+	c.Client = es
+	c.Info = info
+	return c, nil
 }
 
 // Update record by id in elasticsearch
-func (Es *Client) Update(index, id string, data []byte) (*ResponseBody, error) {
-	return update(Es.Client, index, id, data)
+func (Es *Client) Update(index, id string, data []byte, opts ...WriteOption) (rb *ResponseBody, err error) {
+	ctx, span := Es.startSpan("update", index, id)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "update", index, id, true)
+	defer func() { a.finish(data, rb, err) }()
+
+	return update(ctx, Es.Client, index, id, data, Es.write(opts))
 }
 
 // Exists checks if there's a document with such id in such an index
-func (Es *Client) Exists(index string, id string) (bool, error) {
-	return exists(Es.Client, index, id)
+func (Es *Client) Exists(index string, id string) (ok bool, err error) {
+	ctx, span := Es.startSpan("exists", index, id)
+	defer func() { endSpan(span, err) }()
+
+	v, err := Es.coalesce(span, "exists", index, id, func() (interface{}, error) {
+		ok, err := exists(ctx, Es.Client, index, id)
+		if !ok || err != nil || !Es.softDelete {
+			return ok, err
+		}
+		src, err := source(ctx, Es.Client, index, id)
+		if err != nil {
+			return false, err
+		}
+		return Es.checkDeletedSource(index, id, src) == nil, nil
+	})
+	ok, _ = v.(bool)
+	return ok, err
 }
 
 // BulkCreate let's bulky index multiple entries by single request to Elastic.
 // look full documentation here: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html#docs-bulk-api-example
-func (Es *Client) BulkCreate(datum []byte) error {
+// With WithSoftDelete the delete actions mark the documents deleted, they're sent as updates.
+func (Es *Client) BulkCreate(datum []byte, opts ...WriteOption) (err error) {
 	if len(datum) < 2 {
 		return fmt.Errorf("empty data")
 	}
 
-	es := Es.Client
+	ctx, span := Es.startSpan("bulk", "", "")
+	defer func() { endSpan(span, err) }()
+	started := time.Now()
+	body, marked := Es.markBulk(datum, started)
+	var br *bulkResponse
+	defer func() { Es.auditBulk(ctx, started, body, marked, br, err) }()
 
-	res, err := es.Bulk(
-		bytes.NewReader(datum),
-		//es.Bulk.WithPretty(),
-	)
-	if err != nil {
-		return fmt.Errorf("cannot bulky create entries: %v", err)
-	}
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			log.Printf("cannot close response body: %v", err)
-		}
-	}()
+	br, err = Es.bulk(ctx, body, Es.write(opts))
+	return err
+}
 
-	var rb ResponseBody
-	if err := json.NewDecoder(res.Body).Decode(&rb); err != nil {
-		return fmt.Errorf("response contains bad json: %v", err)
-	}
+// Create record in elasticsearch
+// should contain a valid JSON with key {..."id":your_unique_id}
+// Fails with *AlreadyExistsError if there's a document with such id, use Index to overwrite it.
+// Elastic generates the id if it is empty, call CreateAuto to get it back.
+func (Es *Client) Create(index string, id string, data []byte, opts ...WriteOption) (err error) {
+	ctx, span := Es.startSpan("create", index, id)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "create", index, id, false)
+	var rb *ResponseBody
+	defer func() { a.finish(data, rb, err) }()
 
-	if res.IsError() {
-		return fmt.Errorf("bulk create item failed: %v", rb)
-	}
+	rb, err = create(ctx, Es.Client, index, id, data, Es.write(opts))
+	return err
+}
 
-	return nil
+// CreateAuto creates a record with an id generated by elastic, see ResponseBody.ID
+func (Es *Client) CreateAuto(index string, data []byte, opts ...WriteOption) (rb *ResponseBody, err error) {
+	ctx, span := Es.startSpan("create", index, "")
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "create", index, "", false)
+	defer func() { a.finish(data, rb, err) }()
+
+	return create(ctx, Es.Client, index, "", data, Es.write(opts))
 }
 
-// Create record in elasticsearch
-// should contain a valid JSON with key {..."id":your_unique_id}
-func (Es *Client) Create(index string, id string, data []byte) error {
-	return create(Es.Client, index, id, data)
+// Index creates the record or replaces the existing one as a whole.
+// Elastic generates the id if it is empty, see ResponseBody.ID.
+func (Es *Client) Index(index string, id string, data []byte, opts ...WriteOption) (rb *ResponseBody, err error) {
+	ctx, span := Es.startSpan("index", index, id)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "index", index, id, true)
+	defer func() { a.finish(data, rb, err) }()
+
+	return put(ctx, Es.Client, index, id, data, "index", Es.write(opts))
 }
 
-// Delete record by id in elasticsearch
-func (Es *Client) Delete(index, id string) (*ResponseBody, error) {
-	return remove(Es.Client, index, id)
+// Delete record by id in elasticsearch, or mark it deleted with WithSoftDelete
+func (Es *Client) Delete(index, id string, opts ...WriteOption) (rb *ResponseBody, err error) {
+	ctx, span := Es.startSpan("delete", index, id)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "delete", index, id, true)
+	var mark []byte
+	defer func() { a.finish(mark, rb, err) }()
+
+	if Es.softDelete {
+		mark = deleteMark(time.Now())
+		return update(ctx, Es.Client, index, id, mark, Es.write(opts))
+	}
+	return remove(ctx, Es.Client, index, id, Es.write(opts))
 }
 
 // Source get source
-func (Es *Client) Source(index, id string) ([]byte, error) {
-	return source(Es.Client, index, id)
+func (Es *Client) Source(index, id string) (src []byte, err error) {
+	ctx, span := Es.startSpan("source", index, id)
+	defer func() { endSpan(span, err) }()
+
+	v, err := Es.coalesce(span, "source", index, id, func() (interface{}, error) {
+		return source(ctx, Es.Client, index, id)
+	})
+	src, _ = v.([]byte)
+	if err == nil {
+		if err := Es.checkDeletedSource(index, id, src); err != nil {
+			return nil, err
+		}
+	}
+	if Es.flight != nil && src != nil {
+		src = append([]byte(nil), src...)
+	}
+	return src, err
 }
 
-func (Es *Client) Read(index, id string) (*ResponseBody, error) {
-	return read(Es.Client, index, id)
+func (Es *Client) Read(index, id string) (rb *ResponseBody, err error) {
+	ctx, span := Es.startSpan("read", index, id)
+	defer func() { endSpan(span, err) }()
+
+	v, err := Es.coalesce(span, "read", index, id, func() (interface{}, error) {
+		return read(ctx, Es.Client, index, id)
+	})
+	rb, _ = v.(*ResponseBody)
+	if rb != nil {
+		if err := Es.checkDeleted(index, id, rb.Source); err != nil {
+			return nil, err
+		}
+	}
+	if Es.flight != nil && rb != nil {
+		c := *rb
+		rb = &c
+	}
+	return rb, err
 }
 
 // IncrementField пересчитать просмотры в материале
 // аналог запроса
 // POST http://localhost:9200/article/_update/{{id}}/
 // { "script" : "ctx._source.viewed+={{amount}}" }
-func (Es *Client) IncrementField(index string, docID string, fieldName string, incr int) (*ResponseBody, error) {
+func (Es *Client) IncrementField(index string, docID string, fieldName string, incr int, opts ...WriteOption) (upd *ResponseBody, err error) {
+	ctx, span := Es.startSpan("increment", index, docID)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "increment", index, docID, false)
+	defer func() { a.finish(nil, upd, err) }()
+
 	templ := fmt.Sprintf(`
 {
   "script": {
@@ -137,16 +255,20 @@ func (Es *Client) IncrementField(index string, docID string, fieldName string, i
 }
 `, fieldName, incr)
 
-	var upd *ResponseBody
+	a.scriptOf([]byte(templ))
+
 	res, err := Es.Client.Update(
 		index,
 		docID,
 		bytes.NewReader([]byte(templ)),
+		Es.Client.Update.WithContext(ctx),
+		Es.write(opts).update,
 	)
 	if err != nil {
 		return upd, fmt.Errorf("cannot update entry: %v", err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 
 	if res.IsError() {
 		return upd, fmt.Errorf("increment failed. Status: %s, err: %v", res.Status(), err)
@@ -167,7 +289,12 @@ func (Es *Client) IncrementField(index string, docID string, fieldName string, i
 // InsertArrayItem добавить элемент массива. Массива может не быть - тогда добавить и массив
 // POST http://localhost:9200/mask/_update/_3/
 // "script": "if (!ctx._source.containsKey(\"attending\")) { ctx._source.attending = newField }",
-func (Es *Client) InsertArrayItem(index string, docID string, arrayName string, elem []byte) (*ResponseBody, error) {
+func (Es *Client) InsertArrayItem(index string, docID string, arrayName string, elem []byte, opts ...WriteOption) (upd *ResponseBody, err error) {
+	ctx, span := Es.startSpan("insert_array_item", index, docID)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "insert_array_item", index, docID, false)
+	defer func() { a.finish(nil, upd, err) }()
+
 	templ := fmt.Sprintf(`
 {
   "script": {
@@ -179,17 +306,21 @@ func (Es *Client) InsertArrayItem(index string, docID string, arrayName string,
 }
 `, arrayName, fmt.Sprintf("%s", elem))
 
-	var upd *ResponseBody
+	a.scriptOf([]byte(templ))
+
 	res, err := Es.Client.Update(
 		index,
 		docID,
 		bytes.NewReader([]byte(templ)),
+		Es.Client.Update.WithContext(ctx),
+		Es.write(opts).update,
 		Es.Client.Update.WithPretty(),
 	)
 	if err != nil {
 		return upd, fmt.Errorf("cannot update entry: %v", err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 
 	if res.IsError() {
 		return upd, fmt.Errorf("insert failed. Status: %s, err: %v", res.Status(), err)
@@ -210,7 +341,12 @@ func (Es *Client) InsertArrayItem(index string, docID string, arrayName string,
 // UpdateArrayItem заменить элемент массива по его параметру (пока только числовой ID)
 // POST http://localhost:9200/mask/_update/_3/
 // { "script": { "inline": "ctx._source.mask_articles.removeIf(li -> li.article_id == params.article_id);", "lang": "painless", "params": { "article_id": 1886746, "cat": { "article_id": 1886746, "position": 5 } } } }
-func (Es *Client) UpdateArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, subst []byte) (*ResponseBody, error) {
+func (Es *Client) UpdateArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, subst []byte, opts ...WriteOption) (upd *ResponseBody, err error) {
+	ctx, span := Es.startSpan("update_array_item", index, docID)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "update_array_item", index, docID, false)
+	defer func() { a.finish(nil, upd, err) }()
+
 	templ := fmt.Sprintf(`
 {
   "script": {
@@ -223,17 +359,21 @@ func (Es *Client) UpdateArrayItem(index string, docID string, arrayName string,
 }
 `, arrayName, itemName, itemValue, fmt.Sprintf("%s", subst))
 
-	var upd *ResponseBody
+	a.scriptOf([]byte(templ))
+
 	res, err := Es.Client.Update(
 		index,
 		docID,
 		bytes.NewReader([]byte(templ)),
+		Es.Client.Update.WithContext(ctx),
+		Es.write(opts).update,
 		Es.Client.Update.WithPretty(),
 	)
 	if err != nil {
 		return upd, fmt.Errorf("cannot update entry: %v", err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 
 	if res.IsError() {
 		return upd, fmt.Errorf("update item failed. Status: %s, err: %v", res.Status(), err)
@@ -254,7 +394,12 @@ func (Es *Client) UpdateArrayItem(index string, docID string, arrayName string,
 // RemoveArrayItem удалить элемент массива по его параметру (пока только числовой ID)
 // POST http://localhost:9200/mask/_update/_3/
 // { "script": { "source": "ctx._source.mask_articles.removeIf(li -> li.article_id == params.article_id)", "params": { "article_id": 1886746 } } }
-func (Es *Client) RemoveArrayItem(index string, docID string, arrayName string, itemName string, itemValue int) (*ResponseBody, error) {
+func (Es *Client) RemoveArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, opts ...WriteOption) (upd *ResponseBody, err error) {
+	ctx, span := Es.startSpan("remove_array_item", index, docID)
+	defer func() { endSpan(span, err) }()
+	a := Es.startAudit(ctx, "remove_array_item", index, docID, false)
+	defer func() { a.finish(nil, upd, err) }()
+
 	templ := fmt.Sprintf(`
 {
   "script": {
@@ -266,17 +411,21 @@ func (Es *Client) RemoveArrayItem(index string, docID string, arrayName string,
 }
 `, arrayName, itemName, itemValue)
 
-	var upd *ResponseBody
+	a.scriptOf([]byte(templ))
+
 	res, err := Es.Client.Update(
 		index,
 		docID,
 		bytes.NewReader([]byte(templ)),
+		Es.Client.Update.WithContext(ctx),
+		Es.write(opts).update,
 		Es.Client.Update.WithPretty(),
 	)
 	if err != nil {
 		return upd, fmt.Errorf("cannot update entry: %v", err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 
 	if res.IsError() {
 		return upd, fmt.Errorf("remove item failed. Status: %s, err: %v", res.Status(), err)
@@ -294,21 +443,28 @@ func (Es *Client) RemoveArrayItem(index string, docID string, arrayName string,
 	return upd, nil
 }
 
-func update(es *elasticsearch.Client, index, id string, data []byte) (*ResponseBody, error) {
+func update(ctx context.Context, es *elasticsearch.Client, index, id string, data []byte, wo WriteOptions) (*ResponseBody, error) {
 	templ := []byte(`{"doc":`)
 	templ = append(templ, data...)
 	templ = append(templ, []byte(`}`)...)
+	return updateBody(ctx, es, index, id, templ, wo)
+}
 
+// updateBody sends the body of an update request, a partial document or a script
+func updateBody(ctx context.Context, es *elasticsearch.Client, index, id string, body []byte, wo WriteOptions) (*ResponseBody, error) {
 	res, err := es.Update(
 		index,
 		id,
-		bytes.NewReader(templ),
+		bytes.NewReader(body),
+		es.Update.WithContext(ctx),
+		wo.update,
 		es.Update.WithPretty(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("cannot update entry: %v", err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 
 	if res.IsError() {
 		return nil, fmt.Errorf("update failed. Status: %s, err: %v", res.Status(), err)
@@ -327,7 +483,7 @@ func update(es *elasticsearch.Client, index, id string, data []byte) (*ResponseB
 }
 
 // Exists checks if there's a document with such id in such an index
-func exists(es *elasticsearch.Client, index string, id string) (exists bool, err error) {
+func exists(ctx context.Context, es *elasticsearch.Client, index string, id string) (exists bool, err error) {
 	if len(id) < 1 {
 		return false, fmt.Errorf("id too short")
 	}
@@ -336,10 +492,11 @@ func exists(es *elasticsearch.Client, index string, id string) (exists bool, err
 		return false, fmt.Errorf("index name too short")
 	}
 
-	res, err := es.Exists(index, id)
+	res, err := es.Exists(index, id, es.Exists.WithContext(ctx))
 	if err != nil {
 		return false, err
 	}
+	spanStatus(ctx, res)
 	switch res.StatusCode {
 	case 200:
 		return true, nil
@@ -350,45 +507,74 @@ func exists(es *elasticsearch.Client, index string, id string) (exists bool, err
 	}
 }
 
-func create(es *elasticsearch.Client, index string, id string, data []byte) (err error) {
+func create(ctx context.Context, es *elasticsearch.Client, index string, id string, data []byte, wo WriteOptions) (*ResponseBody, error) {
+	return put(ctx, es, index, id, data, "create", wo)
+}
+
+// put indexes the document with the given op_type: "create" fails if it exists, "index" overwrites it.
+// elastic generates an id if the id is empty.
+func put(ctx context.Context, es *elasticsearch.Client, index string, id string, data []byte, opType string, wo WriteOptions) (*ResponseBody, error) {
 	if len(data) < 1 {
-		data = []byte(fmt.Sprintf(`{"id":%s}`, id))
+		data = emptyDoc(id)
 	}
 
 	res, err := es.Index(
 		index,
 		bytes.NewReader(data),
 		es.Index.WithDocumentID(id),
+		es.Index.WithOpType(opType),
+		es.Index.WithContext(ctx),
+		wo.index,
 		es.Index.WithPretty(),
 	)
 	if err != nil {
-		return fmt.Errorf("cannot create entry: %v", err)
+		return nil, fmt.Errorf("cannot %s entry: %v", opType, err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 
 	resp, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("cannot read response body: %v", err)
+		return nil, fmt.Errorf("cannot read response body: %v", err)
+	}
+
+	if res.StatusCode == http.StatusConflict && opType == "create" {
+		return nil, newAlreadyExistsError(index, id, resp)
 	}
 
 	if res.IsError() {
-		return fmt.Errorf("create item failed: %s", resp)
+		return nil, fmt.Errorf("%s item failed: %s", opType, resp)
 	}
 
-	var rb ResponseBody
+	var rb *ResponseBody
 	if err := json.Unmarshal(resp, &rb); err != nil {
-		return fmt.Errorf("response contains bad json: %v", err)
+		return rb, fmt.Errorf("response contains bad json: %v", err)
+	}
+	return rb, nil
+}
+
+// emptyDoc is the document stored for empty data: {"id":your_id}, or {} if elastic generates the id
+func emptyDoc(id string) []byte {
+	if id == "" {
+		return []byte(`{}`)
+	}
+	if _, err := strconv.ParseFloat(id, 64); err == nil {
+		return []byte(fmt.Sprintf(`{"id":%s}`, id))
 	}
-	return nil
+	doc, _ := json.Marshal(map[string]string{"id": id})
+	return doc
 }
 
-func remove(es *elasticsearch.Client, index, id string) (*ResponseBody, error) {
+func remove(ctx context.Context, es *elasticsearch.Client, index, id string, wo WriteOptions) (*ResponseBody, error) {
 	res, err := es.Delete(index, id,
+		es.Delete.WithContext(ctx),
+		wo.delete,
 		es.Delete.WithPretty())
 	if err != nil {
 		return nil, fmt.Errorf("cannot delete entry: %v", err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 	if res.IsError() {
 		return nil, fmt.Errorf("remove item failed: %v", err)
 	}
@@ -405,13 +591,15 @@ func remove(es *elasticsearch.Client, index, id string) (*ResponseBody, error) {
 	return rb, nil
 }
 
-func source(es *elasticsearch.Client, index, id string) ([]byte, error) {
+func source(ctx context.Context, es *elasticsearch.Client, index, id string) ([]byte, error) {
 	res, err := es.GetSource(index, id,
+		es.GetSource.WithContext(ctx),
 		es.GetSource.WithPretty())
 	if err != nil {
 		return nil, fmt.Errorf("cannot read entry: %v", err)
 	}
 	defer res.Body.Close()
+	spanStatus(ctx, res)
 
 	if res.IsError() {
 		return nil, fmt.Errorf("get source failed: %v", err)
@@ -424,13 +612,15 @@ func source(es *elasticsearch.Client, index, id string) ([]byte, error) {
 	return resp, nil
 }
 
-func read(es *elasticsearch.Client, index, id string) (*ResponseBody, error) {
+func read(ctx context.Context, es *elasticsearch.Client, index, id string) (*ResponseBody, error) {
 	res, err := es.Get(index, id,
 		//Es.Get.WithSourceIncludes("text,user"),
+		es.Get.WithContext(ctx),
 		es.Get.WithPretty())
 	if err != nil {
 		return nil, fmt.Errorf("cannot read entry: %v", err)
 	}
+	spanStatus(ctx, res)
 	if res.IsError() {
 		return nil, fmt.Errorf("read failed: %v", err)
 	}
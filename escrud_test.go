@@ -1,19 +1,49 @@
 package escrud
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/RGRU/escrud/escrudtest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
+var testHost, testPort = testAddr()
+
 var Es = Conn()
 
+// testAddr returns the cluster from ELASTIC env, or starts a fake one if it is not set
+func testAddr() (string, int) {
+	if host := os.Getenv("ELASTIC"); host != "" {
+		return host, 9200
+	}
+	srv := escrudtest.NewServer()
+	return srv.Host(), srv.Port()
+}
+
 // Connect to elasticsearch
 func Conn() *Client {
-	es, err := Connect(os.Getenv("ELASTIC"), 9200, "http")
+	es, err := Connect(testHost, testPort, "http")
 	if err != nil {
 		fmt.Println("Elasticsearch error:", err)
+		os.Exit(1)
 	}
 	fmt.Println("Elasticsearch info:", es.Info)
 	return es
@@ -72,6 +102,36 @@ func TestBatchCreateRead(t *testing.T) {
 	}
 }
 
+func TestBulk(t *testing.T) {
+	id := "bulk-asdfasdfasdf1"
+	if err := Es.Create("test", id, []byte(`{"viewed": 1}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer Es.Delete("test", id)
+	defer Es.Delete("test", id+"2")
+
+	items, err := Es.Bulk([]byte(`{"create": {"_index": "test", "_id": "` + id + `"}}
+{"viewed": 2}
+{"index": {"_index": "test", "_id": "` + id + `2"}}
+{"viewed": 3}
+`))
+	if err != nil {
+		t.Fatalf("refused actions shouldn't fail the request: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("should be 2 items, got %+v", items)
+	}
+	if it := items[0]; it.Action != "create" || it.ID != id || it.Status != 409 || it.Error == "" {
+		t.Errorf("create of an existing document should fail, got %+v", it)
+	}
+	if it := items[1]; it.Action != "index" || it.ID != id+"2" || it.Result != "created" || it.Error != "" {
+		t.Errorf("index should succeed, got %+v", it)
+	}
+	if src, _ := Es.Source("test", id); strings.TrimSpace(string(src)) != `{"viewed":1}` {
+		t.Errorf("document shouldn't change, got %s", src)
+	}
+}
+
 func TestCreateSource(t *testing.T) {
 	err := Es.Create("test", "2", []byte(`{
 			"user": "barsuk",
@@ -100,6 +160,79 @@ func TestCreateSource(t *testing.T) {
 	if parsed.Text != "как изменилась сеть?" {
 		t.Errorf("bad result: %v", err)
 	}
+
+	if _, err = Es.Delete("test", "2"); err != nil {
+		t.Errorf("cannot delete id %s: %v", "2", err)
+	}
+}
+
+func TestCreateAlreadyExistsIndex(t *testing.T) {
+	id := "exists-asdfasdfasdf1"
+	if err := Es.Create("test", id, []byte(`{"user": "slivki"}`)); err != nil {
+		t.Errorf("ERR: %v", err)
+	}
+
+	err := Es.Create("test", id, []byte(`{"user": "barsuk"}`))
+	var exists *AlreadyExistsError
+	if !errors.As(err, &exists) || exists.ID != id {
+		t.Errorf("should be AlreadyExistsError, got %v", err)
+	}
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("should be ErrAlreadyExists, got %v", err)
+	}
+
+	idx, err := Es.Index("test", id, []byte(`{"user": "barsuk"}`))
+	if err != nil {
+		t.Errorf("cannot index id %s: %v", id, err)
+	} else if idx.Result != "updated" {
+		t.Errorf("doc %s has not been replaced: %s", id, idx.Result)
+	}
+
+	got, err := Es.Read("test", id)
+	if err != nil {
+		t.Fatalf("cannot read id %s: %v", id, err)
+	}
+	if source := (got.Source).(map[string]interface{}); source["user"] != "barsuk" {
+		t.Errorf("should be `barsuk`! But : %s", source["user"])
+	}
+
+	if _, err = Es.Delete("test", id); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+}
+
+func TestIndexGeneratedID(t *testing.T) {
+	idx, err := Es.Index("test", "", []byte(`{"user": "slivki"}`))
+	if err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	if idx.ID == "" || idx.Result != "created" {
+		t.Fatalf("should be created with a generated id: %+v", idx)
+	}
+
+	if ok, err := Es.Exists("test", idx.ID); !ok {
+		t.Errorf("there should be the id %s: %v", idx.ID, err)
+	}
+
+	if _, err = Es.Delete("test", idx.ID); err != nil {
+		t.Errorf("cannot delete id %s: %v", idx.ID, err)
+	}
+}
+
+func TestCreateAuto(t *testing.T) {
+	rb, err := Es.CreateAuto("test", []byte(`{"user": "slivki"}`))
+	if err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	if rb.ID == "" || rb.Result != "created" {
+		t.Fatalf("should be created with a generated id: %+v", rb)
+	}
+	if ok, err := Es.Exists("test", rb.ID); !ok {
+		t.Errorf("there should be the id %s: %v", rb.ID, err)
+	}
+	if _, err = Es.Delete("test", rb.ID); err != nil {
+		t.Errorf("cannot delete id %s: %v", rb.ID, err)
+	}
 }
 
 func TestCreateDelete(t *testing.T) {
@@ -562,3 +695,1654 @@ func _Read(t *testing.T) {
 
 	fmt.Println(got.Source)
 }
+
+func TestTracingSpans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	es, err := Connect(testHost, testPort, "http", WithTracerProvider(tp))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "handler")
+	id := "trace-asdfasdfasdf1"
+	if err := es.WithContext(ctx).Create("test", id, []byte(`{"user": "slivki"}`)); err != nil {
+		t.Errorf("ERR: %v", err)
+	}
+	if _, err := es.WithContext(ctx).Delete("test", id); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+	parent.End()
+
+	var create sdktrace.ReadOnlySpan
+	var transport int
+	for _, s := range sr.Ended() {
+		switch s.Name() {
+		case "elasticsearch.create":
+			create = s
+		case "HTTP PUT":
+			transport++
+		}
+	}
+	if create == nil {
+		t.Fatalf("there is no create span")
+	}
+	if create.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("create span should be a child of the caller span")
+	}
+	if transport == 0 {
+		t.Errorf("there is no transport span")
+	}
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range create.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["db.system"].AsString() != "elasticsearch" {
+		t.Errorf("bad db.system: %v", attrs["db.system"])
+	}
+	if attrs["db.elasticsearch.doc_id"].AsString() != id {
+		t.Errorf("bad doc id: %v", attrs["db.elasticsearch.doc_id"])
+	}
+	if attrs["http.status_code"].AsInt64() != 201 {
+		t.Errorf("bad status code: %v", attrs["http.status_code"])
+	}
+}
+
+func TestTransportContext(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-stop:
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	timeout, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancelNow)
+
+	for name, ctx := range map[string]context.Context{"deadline": timeout, "cancel": cancelled} {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/article/_doc/1", nil)
+		started := time.Now()
+		if _, err := (&Transport{}).RoundTrip(req); err == nil {
+			t.Errorf("%s: request should fail", name)
+		}
+		if d := time.Since(started); d > time.Second {
+			t.Errorf("%s: request should stop with the context, took %v", name, d)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "create_read.json")
+	id := "record-asdfasdfasdf1"
+
+	run := func(mode escrudtest.Mode, host string, port int) {
+		rec, err := escrudtest.NewRecorder(cassette, mode, &Transport{})
+		if err != nil {
+			t.Fatalf("ERR: %v", err)
+		}
+		es, err := Connect(host, port, "http", WithTransport(rec))
+		if err != nil {
+			t.Fatalf("cannot connect: %v", err)
+		}
+
+		if err := es.Create("test", id, []byte(`{"user": "slivki"}`)); err != nil {
+			t.Errorf("ERR: %v", err)
+		}
+		got, err := es.Read("test", id)
+		if err != nil {
+			t.Fatalf("cannot read id %s: %v", id, err)
+		}
+		if source := (got.Source).(map[string]interface{}); source["user"] != "slivki" {
+			t.Errorf("should be `slivki`! But : %s", source["user"])
+		}
+		if _, err = es.Delete("test", id); err != nil {
+			t.Errorf("cannot delete id %s: %v", id, err)
+		}
+
+		if err := rec.Stop(); err != nil {
+			t.Errorf("cannot save cassette: %v", err)
+		}
+	}
+
+	run(escrudtest.ModeAuto, testHost, testPort)
+
+	// nothing listens there, everything should come from the cassette
+	run(escrudtest.ModeAuto, "127.0.0.1", 1)
+}
+
+// queryRecorder remembers query strings of the requests it passes to the Transport
+type queryRecorder struct {
+	Transport
+	queries []url.Values
+}
+
+func (q *queryRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	q.queries = append(q.queries, req.URL.Query())
+	return q.Transport.RoundTrip(req)
+}
+
+func TestWriteOptions(t *testing.T) {
+	rec := &queryRecorder{}
+	es, err := Connect(testHost, testPort, "http", WithTransport(rec),
+		WithWriteOptions(Refresh(RefreshWaitFor), WaitForActiveShards("1")))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+
+	id := "writeopts-asdfasdfasdf1"
+	if err := es.Create("test", id, []byte(`{"user": "slivki"}`), Routing("slivki"), Timeout(time.Second)); err != nil {
+		t.Errorf("ERR: %v", err)
+	}
+	if _, err := es.IncrementField("test", id, "viewed", 1, Refresh(RefreshFalse), Routing("slivki")); err == nil {
+		t.Errorf("there is no viewed field to increment")
+	}
+	if _, err := es.Delete("test", id, Routing("slivki")); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+
+	// info, create, increment and delete
+	if len(rec.queries) != 4 {
+		t.Fatalf("should be 4 requests, got %d", len(rec.queries))
+	}
+
+	create := rec.queries[1]
+	if create.Get("refresh") != "wait_for" || create.Get("wait_for_active_shards") != "1" ||
+		create.Get("routing") != "slivki" || create.Get("timeout") != "1000ms" {
+		t.Errorf("bad create params: %v", create)
+	}
+
+	if incr := rec.queries[2]; incr.Get("refresh") != "false" || incr.Get("wait_for_active_shards") != "1" {
+		t.Errorf("per-call refresh should override the default: %v", incr)
+	}
+
+	if del := rec.queries[3]; del.Get("refresh") != "wait_for" || del.Get("timeout") != "" {
+		t.Errorf("bad delete params: %v", del)
+	}
+}
+
+type maskArticle struct {
+	ArticleID int `json:"article_id"`
+	Position  int `json:"position"`
+}
+
+// maskArticles reads the mask_articles array of the test document
+func maskArticles(t *testing.T, id string) []maskArticle {
+	t.Helper()
+	got, err := Es.Source("test", id)
+	if err != nil {
+		t.Fatalf("cannot read id %s: %v", id, err)
+	}
+	var parsed struct {
+		MaskArticles []maskArticle `json:"mask_articles"`
+	}
+	if err := json.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("cannot parse json answer: %v", err)
+	}
+	return parsed.MaskArticles
+}
+
+func articleIDs(articles []maskArticle) []int {
+	ids := make([]int, len(articles))
+	for i, a := range articles {
+		ids[i] = a.ArticleID
+	}
+	return ids
+}
+
+func TestArrayReorder(t *testing.T) {
+	id := "test-6asdfasdfasdf6"
+	err := Es.Create("test", id, []byte(`{
+			"mask_articles":[{"article_id":1,"position":3},{"article_id":2,"position":1},{"article_id":3,"position":2}]
+		}`))
+	if err != nil {
+		t.Errorf("ERR: %v", err)
+	}
+
+	if _, err := Es.SortArrayItems("test", id, "mask_articles", "position", false); err != nil {
+		t.Fatalf("cannot sort id %s: %v", id, err)
+	}
+	if got := fmt.Sprint(articleIDs(maskArticles(t, id))); got != "[2 3 1]" {
+		t.Errorf("should be sorted by position, got %s", got)
+	}
+
+	if _, err := Es.MoveArrayItem("test", id, "mask_articles", "article_id", 1, 0); err != nil {
+		t.Fatalf("cannot move in id %s: %v", id, err)
+	}
+	if got := fmt.Sprint(articleIDs(maskArticles(t, id))); got != "[1 2 3]" {
+		t.Errorf("1 should be moved to the start, got %s", got)
+	}
+
+	upd, err := Es.MoveArrayItem("test", id, "mask_articles", "article_id", 100, 0)
+	if err != nil {
+		t.Fatalf("cannot move in id %s: %v", id, err)
+	}
+	if upd.Result != "noop" {
+		t.Errorf("moving of absent item should be noop, got %s", upd.Result)
+	}
+
+	if _, err := Es.CapArray("test", id, "mask_articles", 2); err != nil {
+		t.Fatalf("cannot cap id %s: %v", id, err)
+	}
+	if got := fmt.Sprint(articleIDs(maskArticles(t, id))); got != "[1 2]" {
+		t.Errorf("should be capped to 2 items, got %s", got)
+	}
+
+	if _, err = Es.Delete("test", id); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+}
+
+func TestArrayInsertions(t *testing.T) {
+	id := "test-7asdfasdfasdf7"
+	if err := Es.Create("test", id, []byte(`{"user": "slivki"}`)); err != nil {
+		t.Errorf("ERR: %v", err)
+	}
+
+	_, err := Es.InsertArrayItems("test", id, "mask_articles", [][]byte{
+		[]byte(`{"article_id":1,"position":1}`),
+		[]byte(`{"article_id":2,"position":2}`),
+	})
+	if err != nil {
+		t.Fatalf("cannot insert into id %s: %v", id, err)
+	}
+
+	if _, err := Es.InsertArrayItemAt("test", id, "mask_articles", 1, []byte(`{"article_id":3,"position":5}`)); err != nil {
+		t.Fatalf("cannot insert into id %s: %v", id, err)
+	}
+	if got := fmt.Sprint(articleIDs(maskArticles(t, id))); got != "[1 3 2]" {
+		t.Errorf("3 should be inserted in the middle, got %s", got)
+	}
+
+	upd, err := Es.InsertArrayItemIfAbsent("test", id, "mask_articles", "article_id", []byte(`{"article_id":3,"position":7}`))
+	if err != nil {
+		t.Fatalf("cannot insert into id %s: %v", id, err)
+	}
+	if upd.Result != "noop" {
+		t.Errorf("3 is there already, should be noop, got %s", upd.Result)
+	}
+
+	if _, err := Es.InsertArrayItemIfAbsent("test", id, "mask_articles", "article_id", []byte(`{"article_id":4,"position":7}`)); err != nil {
+		t.Fatalf("cannot insert into id %s: %v", id, err)
+	}
+	if got := fmt.Sprint(articleIDs(maskArticles(t, id))); got != "[1 3 2 4]" {
+		t.Errorf("4 should be appended, got %s", got)
+	}
+
+	if _, err = Es.Delete("test", id); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+}
+
+func TestPatchArrayItems(t *testing.T) {
+	id := "test-8asdfasdfasdf8"
+	err := Es.Create("test", id, []byte(`{
+			"mask": {"articles": [
+				{"article_id": 1, "position": 3, "meta": {"rubric": "news", "pinned": true}},
+				{"article_id": 2, "position": 1, "meta": {"rubric": "news"}},
+				{"article_id": 2, "position": 2, "meta": {"rubric": "sport"}}
+			]}
+		}`))
+	if err != nil {
+		t.Errorf("ERR: %v", err)
+	}
+
+	upd, err := Es.PatchArrayItems("test", id, "mask.articles",
+		map[string]interface{}{"article_id": 2, "meta.rubric": "news"},
+		[]byte(`{"position": 5, "meta": {"pinned": true}}`))
+	if err != nil {
+		t.Fatalf("cannot patch id %s: %v", id, err)
+	}
+	if upd.Result != "updated" {
+		t.Errorf("cannot patch id %s: %s", id, upd.Result)
+	}
+
+	upd, err = Es.PatchArrayItems("test", id, "mask.articles",
+		map[string]interface{}{"article_id": 1}, []byte(`{"meta": {"pinned": null}}`))
+	if err != nil {
+		t.Fatalf("cannot patch id %s: %v", id, err)
+	}
+
+	upd, err = Es.PatchArrayItems("test", id, "mask.articles",
+		map[string]interface{}{"article_id": 100}, []byte(`{"position": 1}`))
+	if err != nil {
+		t.Fatalf("cannot patch id %s: %v", id, err)
+	}
+	if upd.Result != "noop" {
+		t.Errorf("nothing matches, should be noop, got %s", upd.Result)
+	}
+
+	got, err := Es.Source("test", id)
+	if err != nil {
+		t.Fatalf("cannot read id %s: %v", id, err)
+	}
+	var parsed struct {
+		Mask struct {
+			Articles []struct {
+				ArticleID int `json:"article_id"`
+				Position  int `json:"position"`
+				Meta      struct {
+					Rubric string `json:"rubric"`
+					Pinned bool   `json:"pinned"`
+				} `json:"meta"`
+			} `json:"articles"`
+		} `json:"mask"`
+	}
+	if err := json.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("cannot parse json answer: %v", err)
+	}
+
+	a := parsed.Mask.Articles
+	if len(a) != 3 {
+		t.Fatalf("should be 3 articles, got %d", len(a))
+	}
+	if a[0].Meta.Pinned || a[0].Meta.Rubric != "news" || a[0].Position != 3 {
+		t.Errorf("pinned should be removed from 1 only: %+v", a[0])
+	}
+	if a[1].Position != 5 || !a[1].Meta.Pinned || a[1].Meta.Rubric != "news" {
+		t.Errorf("2 in news should be patched: %+v", a[1])
+	}
+	if a[2].Position != 2 || a[2].Meta.Pinned {
+		t.Errorf("2 in sport should stay: %+v", a[2])
+	}
+
+	if _, err = Es.Delete("test", id); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	ids := []string{"counter-asdfasdfasdf1", "counter-asdfasdfasdf2"}
+	for _, id := range ids {
+		if err := Es.Create("test", id, []byte(`{"viewed": 10}`)); err != nil {
+			t.Errorf("ERR: %v", err)
+		}
+	}
+
+	views := Es.NewCounter(0, FlushSize(10))
+	for i := 0; i < 5; i++ {
+		views.Add("test", ids[0], "viewed", 1)
+		views.Add("test", ids[1], "viewed", 2)
+		views.Add("test", ids[1], "liked", 1)
+	}
+	views.Add("test", "counter-nonexistent", "viewed", 1)
+
+	if st := views.Stats(); st.Pending != 3 || st.Increments != 16 {
+		t.Errorf("bad stats before flush: %+v", st)
+	}
+
+	if err := views.Flush(); err == nil {
+		t.Errorf("should fail on nonexistent document")
+	}
+	if err := views.Close(); err != nil {
+		t.Errorf("nothing to flush, got %v", err)
+	}
+	if err := views.Close(); err != nil {
+		t.Errorf("second close should do nothing, got %v", err)
+	}
+	views.Add("test", ids[0], "viewed", 1)
+	if st := views.Stats(); st.Rejected != 1 || st.Pending != 0 {
+		t.Errorf("add after close should be rejected: %+v", st)
+	}
+
+	st := views.Stats()
+	if st.Pending != 0 || st.Flushes != 1 || st.Updates != 2 || st.Failed != 1 || st.LastError == nil {
+		t.Errorf("bad stats after flush: %+v", st)
+	}
+
+	for i, want := range []string{`{"viewed":15}`, `{"liked":5,"viewed":20}`} {
+		got, err := Es.Source("test", ids[i])
+		if err != nil {
+			t.Errorf("cannot read id %s: %v", ids[i], err)
+		}
+		var parsed map[string]int
+		json.Unmarshal(got, &parsed)
+		if b, _ := json.Marshal(parsed); string(b) != want {
+			t.Errorf("should be %s, got %s", want, b)
+		}
+		if _, err = Es.Delete("test", ids[i]); err != nil {
+			t.Errorf("cannot delete id %s: %v", ids[i], err)
+		}
+	}
+}
+
+func TestFieldOperations(t *testing.T) {
+	id := "test-9asdfasdfasdf9"
+	if err := Es.Create("test", id, []byte(`{"viewed": 5, "stats": {"rating": 4.5, "best": 10}, "tags": ["news"], "draft": true}`)); err != nil {
+		t.Errorf("ERR: %v", err)
+	}
+
+	ops := []struct {
+		name   string
+		do     func() (*ResponseBody, error)
+		result string
+	}{
+		{"decrement", func() (*ResponseBody, error) { return Es.DecrementField("test", id, "viewed", 3, 0) }, "updated"},
+		{"decrement to floor", func() (*ResponseBody, error) { return Es.DecrementField("test", id, "viewed", 3, 0) }, "updated"},
+		{"decrement below floor", func() (*ResponseBody, error) { return Es.DecrementField("test", id, "viewed", 3, 0) }, "noop"},
+		{"increment float", func() (*ResponseBody, error) { return Es.IncrementFloatField("test", id, "stats.rating", 0.25) }, "updated"},
+		{"set max", func() (*ResponseBody, error) { return Es.SetFieldMax("test", id, "stats.best", 12) }, "updated"},
+		{"set max smaller", func() (*ResponseBody, error) { return Es.SetFieldMax("test", id, "stats.best", 11) }, "noop"},
+		{"set min", func() (*ResponseBody, error) { return Es.SetFieldMin("test", id, "stats.worst", 2) }, "updated"},
+		{"set if absent", func() (*ResponseBody, error) {
+			return Es.SetFieldIfAbsent("test", id, "author", []byte(`{"name": "slivki"}`))
+		}, "updated"},
+		{"set if present", func() (*ResponseBody, error) { return Es.SetFieldIfAbsent("test", id, "author", []byte(`"nobody"`)) }, "noop"},
+		{"toggle", func() (*ResponseBody, error) { return Es.ToggleField("test", id, "draft") }, "updated"},
+		{"remove", func() (*ResponseBody, error) { return Es.RemoveField("test", id, "stats.best") }, "updated"},
+		{"remove absent", func() (*ResponseBody, error) { return Es.RemoveField("test", id, "stats.nothing.here") }, "noop"},
+		{"rename", func() (*ResponseBody, error) { return Es.RenameField("test", id, "author.name", "meta.author") }, "updated"},
+		{"append", func() (*ResponseBody, error) { return Es.AppendToStringList("test", id, "tags", "sport", true) }, "updated"},
+		{"append unique", func() (*ResponseBody, error) { return Es.AppendToStringList("test", id, "tags", "news", true) }, "noop"},
+		{"append new list", func() (*ResponseBody, error) { return Es.AppendToStringList("test", id, "labels", "hot", false) }, "updated"},
+	}
+	for _, op := range ops {
+		upd, err := op.do()
+		if err != nil {
+			t.Fatalf("%s failed on id %s: %v", op.name, id, err)
+		}
+		if upd.Result != op.result {
+			t.Errorf("%s should be %s, got %s", op.name, op.result, upd.Result)
+		}
+	}
+
+	got, err := Es.Source("test", id)
+	if err != nil {
+		t.Fatalf("cannot read id %s: %v", id, err)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("cannot parse json answer: %v", err)
+	}
+	want := map[string]interface{}{
+		"viewed": 0.0,
+		"stats":  map[string]interface{}{"rating": 4.75, "worst": 2.0},
+		"tags":   []interface{}{"news", "sport"},
+		"labels": []interface{}{"hot"},
+		"draft":  false,
+		"author": map[string]interface{}{},
+		"meta":   map[string]interface{}{"author": "slivki"},
+	}
+	if !reflect.DeepEqual(parsed, want) {
+		t.Errorf("should be %v, got %s", want, got)
+	}
+
+	if _, err = Es.Delete("test", id); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+}
+
+// createSearchDocs indexes articles for the search tests and returns their ids
+func createSearchDocs(t *testing.T) []string {
+	docs := []string{
+		`{"tag": "search-test", "rubric": "news", "viewed": 10, "date": "2021-03-01T10:00:00Z", "comments": [{"author": "ann", "likes": 3}]}`,
+		`{"tag": "search-test", "rubric": "news", "viewed": 30, "date": "2021-03-01T18:00:00Z", "comments": [{"author": "bob", "likes": 1}, {"author": "ann", "likes": 2}]}`,
+		`{"tag": "search-test", "rubric": "sport", "viewed": 120, "date": "2021-03-03T09:00:00Z"}`,
+	}
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = fmt.Sprintf("search-asdfasdfasdf%d", i)
+		if err := Es.Create("test", ids[i], []byte(doc), Refresh(RefreshTrue)); err != nil {
+			t.Fatalf("ERR: %v", err)
+		}
+	}
+	return ids
+}
+
+func deleteSearchDocs(t *testing.T, ids []string) {
+	for _, id := range ids {
+		if _, err := Es.Delete("test", id, Refresh(RefreshTrue)); err != nil {
+			t.Errorf("cannot delete id %s: %v", id, err)
+		}
+	}
+}
+
+func TestSearchAggregations(t *testing.T) {
+	ids := createSearchDocs(t)
+	defer deleteSearchDocs(t, ids)
+
+	res, err := Es.Search("test", []byte(`{"term": {"tag": "search-test"}}`), Size(2), Sort(Desc("viewed")),
+		Aggs(
+			TermsAgg("rubrics", "rubric", 10).Sub(SumAgg("views", "viewed"), TopHitsAgg("top", 1, Desc("viewed"))),
+			HistogramAgg("views_histogram", "viewed", 50),
+			DateHistogramAgg("by_day", "date", "1d"),
+			RangeAgg("popularity", "viewed", 20, 100),
+			AvgAgg("avg_views", "viewed"),
+			MaxAgg("max_views", "viewed"),
+			CardinalityAgg("rubric_count", "rubric"),
+			NestedAgg("comments", "comments").Sub(TermsAgg("authors", "comments.author", 10)),
+		))
+	if err != nil {
+		t.Fatalf("cannot search: %v", err)
+	}
+
+	if res.Total != 3 || len(res.Hits) != 2 || res.Hits[0].ID != ids[2] {
+		t.Errorf("should find 3 and return 2 sorted by views, got %d: %+v", res.Total, res.Hits)
+	}
+
+	rubrics, err := res.Aggregations.Buckets("rubrics")
+	if err != nil {
+		t.Fatalf("cannot decode rubrics: %v", err)
+	}
+	if len(rubrics) != 2 || rubrics[0].Key != "news" || rubrics[0].DocCount != 2 {
+		t.Fatalf("news should go first with 2 docs, got %+v", rubrics)
+	}
+	if views, err := rubrics[0].Aggregations.Metric("views"); err != nil || *views.Value != 40 {
+		t.Errorf("news should have 40 views, got %v %v", views, err)
+	}
+	if top, err := rubrics[0].Aggregations.TopHits("top"); err != nil || len(top) != 1 || top[0].ID != ids[1] {
+		t.Errorf("top news should be %s, got %+v %v", ids[1], top, err)
+	}
+
+	histogram, err := res.Aggregations.Buckets("views_histogram")
+	if err != nil {
+		t.Fatalf("cannot decode histogram: %v", err)
+	}
+	if got := fmt.Sprint(len(histogram), histogram[0].DocCount, histogram[1].DocCount, histogram[2].DocCount); got != "3 2 0 1" {
+		t.Errorf("histogram should be 0:2 50:0 100:1, got %+v", histogram)
+	}
+
+	days, err := res.Aggregations.Buckets("by_day")
+	if err != nil {
+		t.Fatalf("cannot decode date histogram: %v", err)
+	}
+	if len(days) != 3 || days[0].DocCount != 2 || days[2].Time() != time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("should be 3 days from March 1st, got %+v", days)
+	}
+
+	ranges, err := res.Aggregations.Buckets("popularity")
+	if err != nil {
+		t.Fatalf("cannot decode ranges: %v", err)
+	}
+	if got := fmt.Sprintf("%v %d %d %d", ranges[0].Key, ranges[0].DocCount, ranges[1].DocCount, ranges[2].DocCount); got != "*-20.0 1 1 1" {
+		t.Errorf("each range should have one doc, got %+v", ranges)
+	}
+
+	if avg, err := res.Aggregations.Metric("avg_views"); err != nil || *avg.Value != 160.0/3 {
+		t.Errorf("bad avg: %v %v", avg, err)
+	}
+	if max, err := res.Aggregations.Metric("max_views"); err != nil || *max.Value != 120 {
+		t.Errorf("bad max: %v %v", max, err)
+	}
+	if n, err := res.Aggregations.Metric("rubric_count"); err != nil || *n.Value != 2 {
+		t.Errorf("bad cardinality: %v %v", n, err)
+	}
+
+	comments, err := res.Aggregations.Nested("comments")
+	if err != nil {
+		t.Fatalf("cannot decode nested: %v", err)
+	}
+	authors, err := comments.Aggregations.Buckets("authors")
+	if err != nil || comments.DocCount != 3 || len(authors) != 2 || authors[0].Key != "ann" || authors[0].DocCount != 2 {
+		t.Errorf("ann should have 2 of 3 comments, got %+v %+v %v", comments, authors, err)
+	}
+
+	if _, err := res.Aggregations.Buckets("nothing"); err == nil {
+		t.Errorf("should fail on unknown aggregation")
+	}
+}
+
+func TestCountExistsByQuery(t *testing.T) {
+	ids := createSearchDocs(t)
+	defer deleteSearchDocs(t, ids)
+
+	n, err := Es.Count("test", []byte(`{"bool": {"filter": [{"term": {"tag": "search-test"}}, {"term": {"rubric": "news"}}]}}`))
+	if err != nil {
+		t.Fatalf("cannot count: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("should be 2 news, got %d", n)
+	}
+
+	ok, err := Es.ExistsByQuery("test", []byte(`{"range": {"viewed": {"gte": 100}}}`))
+	if err != nil {
+		t.Fatalf("cannot check existence: %v", err)
+	}
+	if !ok {
+		t.Errorf("popular article should exist")
+	}
+
+	ok, err = Es.ExistsByQuery("test", []byte(`{"term": {"rubric": "culture"}}`))
+	if err != nil {
+		t.Fatalf("cannot check existence: %v", err)
+	}
+	if ok {
+		t.Errorf("there's no culture")
+	}
+}
+
+func TestPager(t *testing.T) {
+	var ids []string
+	for i := 0; i < 7; i++ {
+		id := fmt.Sprintf("pager-asdfasdfasdf%d", i)
+		// pairs of equal views make the _id tiebreaker matter
+		doc := fmt.Sprintf(`{"tag": "pager-test", "viewed": %d, "n": %d}`, i/2, 6-i)
+		if err := Es.Create("test", id, []byte(doc), Refresh(RefreshTrue)); err != nil {
+			t.Fatalf("ERR: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	defer deleteSearchDocs(t, ids)
+
+	pager := Es.NewPager("test", []byte(`{"term": {"tag": "pager-test"}}`), 3, Desc("viewed"))
+	want := []string{ids[6], ids[4], ids[5], ids[2], ids[3], ids[0], ids[1]}
+
+	var got []string
+	cursor, pages := "", 0
+	for {
+		page, err := pager.Page(cursor)
+		if err != nil {
+			t.Fatalf("cannot get page %d: %v", pages, err)
+		}
+		pages++
+		if page.Total != 7 || page.TotalRelation != "eq" {
+			t.Errorf("total should be exactly 7, got %d %s", page.Total, page.TotalRelation)
+		}
+		for _, h := range page.Hits {
+			got = append(got, h.ID)
+		}
+		if cursor = page.Next; cursor == "" {
+			break
+		}
+	}
+	if pages != 3 || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("should be %v in 3 pages, got %v in %d", want, got, pages)
+	}
+
+	page, err := pager.PageAt(2)
+	if err != nil {
+		t.Fatalf("cannot get page 2: %v", err)
+	}
+	if page.Pages != 3 || len(page.Hits) != 3 || page.Hits[0].ID != want[3] {
+		t.Errorf("page 2 of 3 should start with %s, got %+v", want[3], page)
+	}
+	last, err := pager.Page(page.Next)
+	if err != nil || len(last.Hits) != 1 || last.Hits[0].ID != want[6] || last.Next != "" {
+		t.Errorf("cursor of page 2 should lead to the last page, got %+v %v", last, err)
+	}
+
+	if _, err := pager.MaxResultWindow(5).PageAt(2); !errors.Is(err, ErrResultWindow) {
+		t.Errorf("page 2 ends beyond the window, got %v", err)
+	}
+	if _, err := pager.Page("not a cursor"); !errors.Is(err, ErrBadCursor) {
+		t.Errorf("should be bad cursor, got %v", err)
+	}
+
+	// n descends with the id, so it reverses the order of equal views
+	byN := Es.NewPager("test", []byte(`{"term": {"tag": "pager-test"}}`), 4, Desc("viewed")).Tiebreak("n")
+	first, err := byN.Page("")
+	if err != nil {
+		t.Fatalf("cannot get page: %v", err)
+	}
+	second, err := byN.Page(first.Next)
+	if err != nil {
+		t.Fatalf("cannot get page: %v", err)
+	}
+	got = nil
+	for _, h := range append(first.Hits, second.Hits...) {
+		got = append(got, h.ID)
+	}
+	if want := []string{ids[6], ids[5], ids[4], ids[3], ids[2], ids[1], ids[0]}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("should be %v ordered by n, got %v", want, got)
+	}
+}
+
+func TestPagerCursorPrecision(t *testing.T) {
+	// long sort values above 2^53 survive the cursor
+	var h Hit
+	if err := json.Unmarshal([]byte(`{"_id": "1", "sort": [9007199254740993, "a"]}`), &h); err != nil {
+		t.Fatalf("cannot decode hit: %v", err)
+	}
+	cursor, err := encodeCursor(h.Sort)
+	if err != nil {
+		t.Fatalf("cannot encode cursor: %v", err)
+	}
+	after, err := decodeCursor(cursor, 2)
+	if err != nil || fmt.Sprint(after) != "[9007199254740993 a]" || h.ID != "1" {
+		t.Errorf("cursor should keep the sort values, got %v %v", after, err)
+	}
+
+	// pages count the total exactly beyond 10000
+	rec := &bodyRecorder{}
+	es, err := Connect(testHost, testPort, "http", WithTransport(rec))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	if _, err := es.NewPager("test", nil, 1).Page(""); err != nil {
+		t.Fatalf("cannot get page: %v", err)
+	}
+	if body := rec.bodies[len(rec.bodies)-1]; !strings.Contains(body, `"track_total_hits":true`) {
+		t.Errorf("page should track the total hits, got %s", body)
+	}
+}
+
+func TestHighlightSuggest(t *testing.T) {
+	docs := []string{
+		`{"tag": "suggest-test", "title": "Elections in Moscow", "text": "Moscow votes today. The elections end at eight.", "title_suggest": {"input": ["Elections in Moscow"]}}`,
+		`{"tag": "suggest-test", "title": "Football news", "text": "Moscow team wins the cup", "title_suggest": {"input": ["Football news"]}}`,
+	}
+	var ids []string
+	for i, doc := range docs {
+		id := fmt.Sprintf("suggest-asdfasdfasdf%d", i)
+		if err := Es.Create("test", id, []byte(doc), Refresh(RefreshTrue)); err != nil {
+			t.Fatalf("ERR: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	defer deleteSearchDocs(t, ids)
+
+	res, err := Es.Search("test", []byte(`{"bool": {"filter": {"term": {"tag": "suggest-test"}}, "must": {"match": {"text": "elections"}}}}`),
+		Highlight(Highlighter{Fields: []string{"text"}, FragmentSize: 20, PreTags: []string{"<b>"}, PostTags: []string{"</b>"}}),
+		Suggest(
+			TermSuggester("words", "electoins", "text"),
+			PhraseSuggester("did_you_mean", "moscw electoins", "text"),
+			CompletionSuggester("titles", "foot", "title_suggest", 5),
+		))
+	if err != nil {
+		t.Fatalf("cannot search: %v", err)
+	}
+
+	if len(res.Hits) != 1 {
+		t.Fatalf("should find 1 article, got %d", len(res.Hits))
+	}
+	if got := res.Hits[0].Highlight["text"]; len(got) != 1 || got[0] != "The <b>elections</b> end at" {
+		t.Errorf("bad highlight: %q", got)
+	}
+
+	words := res.Suggestions["words"]
+	if len(words) != 1 || len(words[0].Options) == 0 || words[0].Options[0].Text != "elections" {
+		t.Errorf("should suggest elections, got %+v", words)
+	}
+	phrase := res.Suggestions["did_you_mean"]
+	if len(phrase) != 1 || len(phrase[0].Options) == 0 || phrase[0].Options[0].Text != "moscow elections" {
+		t.Errorf("should suggest moscow elections, got %+v", phrase)
+	}
+	titles := res.Suggestions["titles"]
+	if len(titles) != 1 || len(titles[0].Options) != 1 || titles[0].Options[0].ID != ids[1] || titles[0].Options[0].Score == 0 {
+		t.Errorf("should complete to Football news, got %+v", titles)
+	}
+}
+
+func TestMultiSearch(t *testing.T) {
+	ids := createSearchDocs(t)
+	defer deleteSearchDocs(t, ids)
+
+	results, err := Es.MultiSearch([]SearchRequest{
+		{Index: "test", Query: []byte(`{"term": {"rubric": "sport"}}`)},
+		{Index: "test", Query: []byte(`{"unknown_query": {}}`)},
+		{Index: "test", Query: []byte(`{"term": {"tag": "search-test"}}`), Options: []SearchOption{Size(1), Sort(Asc("viewed"))}},
+	})
+	if err != nil {
+		t.Fatalf("cannot msearch: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("should be 3 results, got %d", len(results))
+	}
+
+	if r := results[0]; r.Err != nil || r.Total != 1 || r.Hits[0].ID != ids[2] {
+		t.Errorf("should find sport %s, got %+v", ids[2], r)
+	}
+	if r := results[1]; r.Err == nil || r.SearchResult != nil {
+		t.Errorf("bad query should fail alone, got %+v", r)
+	}
+	if r := results[2]; r.Err != nil || r.Total != 3 || len(r.Hits) != 1 || r.Hits[0].ID != ids[0] {
+		t.Errorf("should find least viewed %s of 3, got %+v", ids[0], r)
+	}
+}
+
+func TestCachedClient(t *testing.T) {
+	id := "cache-asdfasdfasdf1"
+	cache := NewLRUCache(10, time.Minute)
+	es := NewCachedClient(Es, cache)
+
+	if err := es.Create("test", id, []byte(`{"viewed": 1}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if src, err := es.Source("test", id); err != nil || strings.TrimSpace(string(src)) != `{"viewed":1}` {
+			t.Errorf("bad source: %s %v", src, err)
+		}
+		if rb, err := es.Read("test", id); err != nil || rb.ID != id {
+			t.Errorf("bad read: %+v %v", rb, err)
+		}
+	}
+	if st := es.Stats(); st.Hits != 4 || st.Misses != 2 || st.HitRatio() != 4.0/6 {
+		t.Errorf("should be 4 hits of 6, got %+v", st)
+	}
+
+	// writes through the cache invalidate it
+	if _, err := es.IncrementField("test", id, "viewed", 1); err != nil {
+		t.Fatalf("cannot increment: %v", err)
+	}
+	if src, err := es.Source("test", id); err != nil || strings.TrimSpace(string(src)) != `{"viewed":2}` {
+		t.Errorf("should read the update, got %s %v", src, err)
+	}
+
+	// writes bypassing it don't
+	if _, err := Es.IncrementField("test", id, "viewed", 1); err != nil {
+		t.Fatalf("cannot increment: %v", err)
+	}
+	if src, _ := es.Source("test", id); strings.TrimSpace(string(src)) != `{"viewed":2}` {
+		t.Errorf("should be stale, got %s", src)
+	}
+	cache.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if src, _ := es.Source("test", id); strings.TrimSpace(string(src)) != `{"viewed":3}` {
+		t.Errorf("should expire, got %s", src)
+	}
+	cache.now = time.Now
+
+	if _, err := es.Delete("test", id); err != nil {
+		t.Errorf("cannot delete id %s: %v", id, err)
+	}
+	if _, err := es.Source("test", id); err == nil {
+		t.Errorf("deleted document should be gone from the cache")
+	}
+}
+
+// racyClient runs during after reading a source, like a write racing with the read
+type racyClient struct {
+	Interface
+	during func()
+}
+
+func (r *racyClient) Source(index, id string) ([]byte, error) {
+	src, err := r.Interface.Source(index, id)
+	if r.during != nil {
+		during := r.during
+		r.during = nil
+		during()
+	}
+	return src, err
+}
+
+func TestCachedClientRace(t *testing.T) {
+	id := "cache-race-asdfasdf1"
+	racy := &racyClient{Interface: Es}
+	es := NewCachedClient(racy, NewLRUCache(10, time.Minute))
+	if err := Es.Create("test", id, []byte(`{"viewed": 1}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer Es.Delete("test", id)
+
+	// the update lands after the miss read the old source, it must not be cached
+	racy.during = func() {
+		if _, err := es.IncrementField("test", id, "viewed", 1); err != nil {
+			t.Errorf("cannot increment: %v", err)
+		}
+	}
+	if src, err := es.Source("test", id); err != nil || strings.TrimSpace(string(src)) != `{"viewed":1}` {
+		t.Errorf("should return the source read, got %s %v", src, err)
+	}
+	if src, err := es.Source("test", id); err != nil || strings.TrimSpace(string(src)) != `{"viewed":2}` {
+		t.Errorf("stale source cached, got %s %v", src, err)
+	}
+	if src, _ := es.Source("test", id); strings.TrimSpace(string(src)) != `{"viewed":2}` {
+		t.Errorf("fresh source should be cached, got %s", src)
+	}
+	if st := es.Stats(); st.Hits != 1 || st.Misses != 2 {
+		t.Errorf("should be 1 hit of 3, got %+v", st)
+	}
+}
+
+func TestCachedClientContext(t *testing.T) {
+	var actors []string
+	client, err := Connect(testHost, testPort, "http", WithAudit(AuditFunc(func(ctx context.Context, rec AuditRecord) error {
+		actors = append(actors, rec.Actor)
+		return nil
+	})))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	cache := NewCachedClient(client, NewLRUCache(10, time.Minute))
+	es := cache.WithContext(WithActor(context.Background(), "editor"))
+
+	id := "cache-ctx-asdfasdf1"
+	if err := es.Create("test", id, []byte(`{"viewed": 1}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer Es.Delete("test", id)
+	if fmt.Sprint(actors) != "[editor]" {
+		t.Errorf("the context should reach the client, got actors %v", actors)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := es.Source("test", id); err != nil {
+			t.Errorf("cannot read: %v", err)
+		}
+	}
+	if st := cache.Stats(); st.Hits != 1 || st.Misses != 1 {
+		t.Errorf("the bound copy should share the cache, got %+v", st)
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2, 0)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Get("a")
+	c.Set("c", []byte("3"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("b is the least recently used, should be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || string(v) != "1" {
+		t.Errorf("a should stay, got %s", v)
+	}
+	c.Delete("a")
+	if c.Len() != 1 {
+		t.Errorf("should be only c, got %d entries", c.Len())
+	}
+}
+
+// slowTransport counts the requests and holds them for a while, to let concurrent calls overlap
+type slowTransport struct {
+	Transport
+	requests int32
+}
+
+func (s *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&s.requests, 1)
+	time.Sleep(50 * time.Millisecond)
+	return s.Transport.RoundTrip(req)
+}
+
+func TestSingleflight(t *testing.T) {
+	id := "flight-asdfasdfasdf1"
+	if err := Es.Create("test", id, []byte(`{"viewed": 1}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer Es.Delete("test", id)
+
+	slow := &slowTransport{}
+	es, err := Connect(testHost, testPort, "http", WithTransport(slow), WithSingleflight())
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	atomic.StoreInt32(&slow.requests, 0)
+
+	var wg sync.WaitGroup
+	sources := make([][]byte, 10)
+	for i := range sources {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			sources[i], _ = es.Source("test", id)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if rb, err := es.Read("test", id); err != nil || rb.ID != id {
+				t.Errorf("bad read: %+v %v", rb, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if ok, err := es.Exists("test", id); err != nil || !ok {
+				t.Errorf("should exist: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&slow.requests); n != 3 {
+		t.Errorf("should be one request of each kind, got %d", n)
+	}
+	sources[0][0] = 'x'
+	if sources[1][0] != '{' {
+		t.Errorf("callers should get their own copies")
+	}
+}
+
+// failingWriter writes the first n bytes and fails the rest, as a file on a full disk does
+type failingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		n, _ := f.w.Write(p[:f.n])
+		f.n = 0
+		return n, errors.New("disk is full")
+	}
+	f.n -= len(p)
+	return f.w.Write(p)
+}
+
+func TestExportImport(t *testing.T) {
+	ids := createSearchDocs(t)
+	defer deleteSearchDocs(t, ids)
+	query := ExportQuery([]byte(`{"term": {"tag": "search-test"}}`))
+
+	var full bytes.Buffer
+	var progress []Progress
+	var firstBatch int
+	p, err := Es.Export("test", &full, query, BatchSize(2), OnProgress(func(p Progress) {
+		if progress = append(progress, p); len(progress) == 1 {
+			firstBatch = full.Len()
+		}
+	}))
+	if err != nil {
+		t.Fatalf("cannot export: %v", err)
+	}
+	if p.Docs != 3 || p.Total != 3 || len(progress) != 2 || progress[0].Docs != 2 || p.Offset != int64(full.Len()) {
+		t.Errorf("should export 3 docs in 2 batches, got %+v, progress %+v", p, progress)
+	}
+
+	// views are unique among the docs
+	var byViews bytes.Buffer
+	if p, err := Es.Export("test", &byViews, query, BatchSize(2), Tiebreak("viewed")); err != nil || p.Docs != 3 {
+		t.Errorf("should export 3 docs by views, got %+v %v", p, err)
+	}
+
+	// a failure after the first batch is resumed by the cursor after cutting the part of the second one
+	var resumed bytes.Buffer
+	p, err = Es.Export("test", &failingWriter{w: &resumed, n: firstBatch + 10}, query, BatchSize(2))
+	if err == nil || p.Docs != 2 || p.Cursor == "" || p.Offset != int64(firstBatch) {
+		t.Fatalf("export should fail after the first batch of %d bytes, got %+v %v", firstBatch, p, err)
+	}
+	if resumed.Len() != firstBatch+10 {
+		t.Errorf("should write a part of the second batch, got %d bytes", resumed.Len())
+	}
+	resumed.Truncate(int(p.Offset))
+	p, err = Es.Export("test", &resumed, query, BatchSize(2), ResumeExport(p.Cursor))
+	if err != nil || p.Docs != 1 {
+		t.Fatalf("resumed export should write the last doc, got %+v %v", p, err)
+	}
+
+	p, err = Es.Import("test-import", bytes.NewReader(resumed.Bytes()), BatchSize(2), ImportWriteOptions(Refresh(RefreshTrue)))
+	if err != nil || p.Docs != 3 || p.Failed != 0 {
+		t.Fatalf("should import 3 docs, got %+v %v", p, err)
+	}
+	defer func() {
+		for _, id := range ids {
+			Es.Delete("test-import", id)
+		}
+	}()
+	for _, id := range ids {
+		want, _ := Es.Source("test", id)
+		got, err := Es.Source("test-import", id)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("imported %s should be %s, got %s %v", id, want, got, err)
+		}
+	}
+
+	if _, err := Es.Delete("test-import", ids[2], Refresh(RefreshTrue)); err != nil {
+		t.Fatalf("cannot delete: %v", err)
+	}
+	p, err = Es.Import("test-import", bytes.NewReader(full.Bytes()), ResumeImport(2))
+	if err != nil || p.Docs != 3 {
+		t.Fatalf("resumed import should read 3 docs, got %+v %v", p, err)
+	}
+	if ok, _ := Es.Exists("test-import", ids[2]); !ok {
+		t.Errorf("resumed import should index %s", ids[2])
+	}
+
+	if _, err := Es.Import("test-import", strings.NewReader("not gzip")); err == nil {
+		t.Errorf("import of a bad file should fail")
+	}
+
+	// an export of nothing is still a gzip stream, an empty file imports nothing too
+	var empty bytes.Buffer
+	if p, err := Es.Export("test", &empty, ExportQuery([]byte(`{"term": {"tag": "no-such-tag"}}`))); err != nil || p.Docs != 0 || empty.Len() == 0 {
+		t.Errorf("should export an empty gzip stream, got %+v %v, %d bytes", p, err, empty.Len())
+	}
+	if p, err := Es.Import("test-import", &empty); err != nil || p.Docs != 0 {
+		t.Errorf("should import the empty export, got %+v %v", p, err)
+	}
+	if p, err := Es.Import("test-import", strings.NewReader("")); err != nil || p.Docs != 0 {
+		t.Errorf("should import an empty file, got %+v %v", p, err)
+	}
+
+	// the total is counted beyond 10000
+	rec := &bodyRecorder{}
+	es, err := Connect(testHost, testPort, "http", WithTransport(rec))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	if _, err := es.Export("test", io.Discard, query); err != nil {
+		t.Fatalf("cannot export: %v", err)
+	}
+	if body := rec.bodies[len(rec.bodies)-1]; !strings.Contains(body, `"track_total_hits":true`) {
+		t.Errorf("export should track the total hits, got %s", body)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ids := createSearchDocs(t)
+	defer deleteSearchDocs(t, ids)
+	query := ExportQuery([]byte(`{"term": {"tag": "search-test"}}`))
+
+	var calls int64
+	p, err := Es.Copy("test", "test-copy", func(doc Doc) ([]Doc, error) {
+		atomic.AddInt64(&calls, 1)
+		var src map[string]interface{}
+		if err := json.Unmarshal(doc.Source, &src); err != nil {
+			return nil, err
+		}
+		switch src["viewed"].(float64) {
+		case 10:
+			return nil, nil
+		case 120:
+			return []Doc{
+				{ID: doc.ID + "-a", Source: json.RawMessage(`{"part": "a"}`)},
+				{ID: doc.ID + "-b", Source: json.RawMessage("{\n\"part\": \"b\"\n}")},
+			}, nil
+		}
+		src["copied"] = true
+		b, err := json.Marshal(src)
+		return []Doc{{ID: doc.ID, Source: b}}, err
+	}, query, BatchSize(1), Workers(2), RateLimit(1000), ImportWriteOptions(Refresh(RefreshTrue)))
+	if err != nil {
+		t.Fatalf("cannot copy: %v", err)
+	}
+	written := []string{ids[1], ids[2] + "-a", ids[2] + "-b"}
+	defer func() {
+		for _, id := range written {
+			Es.Delete("test-copy", id)
+		}
+	}()
+	if calls != 3 || p.Docs != 3 || p.Total != 3 || p.Written != 3 || p.Dropped != 1 || p.Failed != 0 {
+		t.Errorf("should copy 3 docs writing 3 and dropping 1, got %+v", p)
+	}
+	for _, id := range written {
+		if ok, err := Es.Exists("test-copy", id); !ok {
+			t.Errorf("%s should be copied: %v", id, err)
+		}
+	}
+	rb, err := Es.Read("test-copy", ids[1])
+	if err != nil {
+		t.Fatalf("cannot read %s: %v", ids[1], err)
+	}
+	if src, _ := rb.Source.(map[string]interface{}); src["copied"] != true || src["viewed"] != float64(30) {
+		t.Errorf("%s should be transformed, got %+v", ids[1], rb.Source)
+	}
+	if ok, _ := Es.Exists("test-copy", ids[0]); ok {
+		t.Errorf("%s should be dropped", ids[0])
+	}
+
+	var failedIDs []string
+	p, err = Es.Copy("test", "test-copy", func(doc Doc) ([]Doc, error) {
+		if doc.ID == ids[0] {
+			return nil, errors.New("bad doc")
+		}
+		return nil, nil
+	}, query, OnError(func(id string, err error) {
+		failedIDs = append(failedIDs, id)
+	}))
+	if err == nil || p.Failed != 1 || p.Dropped != 2 || fmt.Sprint(failedIDs) != fmt.Sprint(ids[:1]) {
+		t.Errorf("should fail on %s, got %+v %v %v", ids[0], p, failedIDs, err)
+	}
+
+	// the total is counted beyond 10000
+	rec := &bodyRecorder{}
+	es, err := Connect(testHost, testPort, "http", WithTransport(rec))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	if p, err := es.Copy("test", "test-copy", func(doc Doc) ([]Doc, error) { return nil, nil }, query); err != nil || p.Total != 3 {
+		t.Fatalf("cannot copy: %+v %v", p, err)
+	}
+	if body := rec.bodies[1]; !strings.Contains(body, `"track_total_hits":true`) {
+		t.Errorf("copy should track the total hits, got %s", body)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	lim := newLimiter(100)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		lim.wait(5)
+	}
+	// the third batch waits for the 10 documents before it
+	if d := time.Since(start); d < 90*time.Millisecond || d > time.Second {
+		t.Errorf("3 batches of 5 at 100/s should take 100ms, took %v", d)
+	}
+	newLimiter(0).wait(1000)
+}
+
+func TestDiff(t *testing.T) {
+	old := []byte(`{"title": "a", "viewed": 1, "tags": ["x"], "author": {"name": "ann", "id": 1}, "draft": true}`)
+	new := []byte(`{"title": "b", "viewed": 1.0, "tags": ["x"], "author": {"name": "ann", "id": 2}, "rubric": "news"}`)
+	partial, changes, err := Diff(old, new)
+	if err != nil {
+		t.Fatalf("cannot diff: %v", err)
+	}
+	want := `{"author":{"id":2},"draft":null,"rubric":"news","title":"b","viewed":1.0}`
+	if string(partial) != want {
+		t.Errorf("partial should be %s, got %s", want, partial)
+	}
+	var paths []string
+	for _, c := range changes {
+		paths = append(paths, c.Path)
+	}
+	if fmt.Sprint(paths) != "[author.id draft rubric title viewed]" {
+		t.Errorf("wrong changes %+v", changes)
+	}
+	if changes[1].Old != true || changes[1].New != nil || changes[2].Old != nil {
+		t.Errorf("removed and added fields should have no new and old values, got %+v", changes)
+	}
+
+	if partial, changes, err := Diff(old, old); err != nil || partial != nil || changes != nil {
+		t.Errorf("equal sources should have no diff, got %s %v %v", partial, changes, err)
+	}
+	// a field removed by null stays removed
+	if partial, changes, err := Diff([]byte(`{"title": "b", "draft": null}`), []byte(`{"title": "b"}`)); err != nil || partial != nil || changes != nil {
+		t.Errorf("null field should be missing, got %s %v %v", partial, changes, err)
+	}
+	if _, _, err := Diff(old, []byte(`[1]`)); err == nil {
+		t.Errorf("diff of an array should fail")
+	}
+}
+
+func TestUpdateDiff(t *testing.T) {
+	id := "diff-asdfasdfasdf"
+	if err := Es.Create("test", id, []byte(`{"title": "a", "viewed": 5, "author": {"name": "ann"}}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer Es.Delete("test", id)
+
+	type author struct {
+		Name string `json:"name"`
+	}
+	type article struct {
+		Title  string `json:"title"`
+		Viewed int    `json:"viewed"`
+		Author author `json:"author"`
+	}
+
+	rb, changes, err := Es.UpdateDiff("test", id, article{Title: "a", Viewed: 5, Author: author{"ann"}})
+	if err != nil || rb.Result != "noop" || changes != nil {
+		t.Errorf("unchanged document should be a noop, got %+v %v %v", rb, changes, err)
+	}
+
+	rb, changes, err = Es.UpdateDiff("test", id, article{Title: "b", Viewed: 5, Author: author{"bob"}})
+	if err != nil || rb.Result != "updated" {
+		t.Fatalf("cannot update: %+v %v", rb, err)
+	}
+	if len(changes) != 2 || changes[0].Path != "author.name" || changes[1].Path != "title" || changes[1].New != "b" {
+		t.Errorf("title and author.name should change, got %+v", changes)
+	}
+	src, _ := Es.Source("test", id)
+	var got article
+	if err := json.Unmarshal(src, &got); err != nil || got != (article{Title: "b", Viewed: 5, Author: author{"bob"}}) {
+		t.Errorf("wrong source %s", src)
+	}
+
+	// a field missing from v is removed from the document, not set to null
+	type titled struct {
+		Title  string `json:"title"`
+		Viewed int    `json:"viewed"`
+	}
+	rb, changes, err = Es.UpdateDiff("test", id, titled{Title: "b", Viewed: 5})
+	if err != nil || rb.Result != "updated" || len(changes) != 1 || changes[0].Path != "author" || changes[0].New != nil {
+		t.Fatalf("author should be removed, got %+v %+v %v", rb, changes, err)
+	}
+	if src, _ := Es.Source("test", id); strings.TrimSpace(string(src)) != `{"title":"b","viewed":5}` {
+		t.Errorf("author should be gone, got %s", src)
+	}
+	if rb, changes, err := Es.UpdateDiff("test", id, titled{Title: "b", Viewed: 5}); err != nil || rb.Result != "noop" || changes != nil {
+		t.Errorf("second update should be a noop, got %+v %v %v", rb, changes, err)
+	}
+
+	if _, _, err := Es.UpdateDiff("test", "diff-missing", article{}); err == nil {
+		t.Errorf("update of a missing document should fail")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var mu sync.Mutex
+	var recs []AuditRecord
+	client, err := Connect(testHost, testPort, "http", WithAudit(AuditFunc(func(ctx context.Context, rec AuditRecord) error {
+		mu.Lock()
+		defer mu.Unlock()
+		recs = append(recs, rec)
+		return nil
+	})))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	es := client.WithContext(WithActor(context.Background(), "editor"))
+
+	id := "audit-asdfasdfasdf"
+	if err := es.Create("test", id, []byte(`{"title": "a", "viewed": 1}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	if _, err := es.Update("test", id, []byte(`{"title": "b"}`)); err != nil {
+		t.Fatalf("cannot update: %v", err)
+	}
+	if _, err := es.IncrementField("test", id, "viewed", 2); err != nil {
+		t.Fatalf("cannot increment: %v", err)
+	}
+	if _, err := es.AppendToStringList("test", id, "tags", "x", true); err != nil {
+		t.Fatalf("cannot append: %v", err)
+	}
+	if _, err := es.Delete("test", id); err != nil {
+		t.Fatalf("cannot delete: %v", err)
+	}
+	if _, err := es.Update("test", id, []byte(`{"title": "c"}`)); err == nil {
+		t.Fatalf("update of a deleted document should fail")
+	}
+	bulk := `{"index": {"_index": "test", "_id": "audit-bulk"}}` + "\n" + `{"title": "bulk"}` + "\n" +
+		`{"create": {"_index": "test", "_id": "audit-bulk"}}` + "\n" + `{"title": "dup"}` + "\n" +
+		`{"delete": {"_index": "test", "_id": "audit-bulk"}}` + "\n"
+	if err := es.BulkCreate([]byte(bulk)); err != nil {
+		t.Fatalf("cannot bulk: %v", err)
+	}
+
+	var ops []string
+	for _, r := range recs {
+		ops = append(ops, r.Operation)
+		if r.Actor != "editor" || r.Index != "test" || r.Started.IsZero() || r.Finished.Before(r.Started) {
+			t.Errorf("wrong record %+v", r)
+		}
+	}
+	want := "[create update increment append_to_string_list delete update bulk_index bulk_create bulk_delete]"
+	if fmt.Sprint(ops) != want {
+		t.Fatalf("operations should be %s, got %v", want, ops)
+	}
+	source := func(raw json.RawMessage) map[string]interface{} {
+		var m map[string]interface{}
+		json.Unmarshal(raw, &m)
+		return m
+	}
+	if string(recs[0].Data) != `{"title": "a", "viewed": 1}` || source(recs[0].After)["title"] != "a" || recs[0].Before != nil || recs[0].Result != "created" {
+		t.Errorf("create should have the data and the source after, got %+v", recs[0])
+	}
+	if source(recs[1].Before)["title"] != "a" || string(recs[1].Data) != `{"title": "b"}` ||
+		source(recs[1].After)["title"] != "b" || source(recs[1].After)["viewed"] != 1.0 {
+		t.Errorf("update should have the sources before and after, got %s %s", recs[1].Before, recs[1].After)
+	}
+	if !strings.Contains(string(recs[2].Script), `"id": 2`) || !strings.Contains(string(recs[3].Script), `"tags"`) {
+		t.Errorf("scripted updates should have the script, got %s and %s", recs[2].Script, recs[3].Script)
+	}
+	if source(recs[2].After)["viewed"] != 3.0 {
+		t.Errorf("increment should have the source after, got %s", recs[2].After)
+	}
+	if recs[4].Before == nil || recs[4].After != nil || recs[4].Result != "deleted" || recs[5].Error == "" || recs[5].After != nil {
+		t.Errorf("delete should have before and the failed update the error, got %+v %+v", recs[4], recs[5])
+	}
+	if r := recs[6]; r.ID != "audit-bulk" || string(r.Data) != `{"title": "bulk"}` || string(r.After) != `{"title": "bulk"}` || r.Status != 201 || r.Error != "" {
+		t.Errorf("wrong bulk index record %+v", r)
+	}
+	if r := recs[7]; r.Status != 409 || r.Error == "" || r.After != nil {
+		t.Errorf("refused bulk create should have the error, got %+v", r)
+	}
+	if r := recs[8]; r.Result != "deleted" || r.Data != nil || r.After != nil {
+		t.Errorf("wrong bulk delete record %+v", r)
+	}
+
+	// the record of a create without id has the id elastic generated
+	recs = nil
+	if err := es.Create("test", "", []byte(`{"title": "auto"}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	if len(recs) != 1 || recs[0].ID == "" {
+		t.Fatalf("create record should have the generated id, got %+v", recs)
+	}
+	Es.Delete("test", recs[0].ID)
+
+	// the index sink writes records by the audited client without auditing them
+	sinkES, err := Connect(testHost, testPort, "http")
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	sinkES = sinkES.AuditTo(NewIndexAuditSink(sinkES, "test-audit"))
+	if err := sinkES.WithContext(WithActor(context.Background(), "editor")).Create("test", id, []byte(`{"title": "a"}`), Refresh(RefreshTrue)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer Es.Delete("test", id)
+	res, err := Es.Search("test-audit", []byte(`{"term": {"id": "`+id+`"}}`))
+	if err != nil || res.Total != 1 {
+		t.Fatalf("audit index should have the record, got %+v %v", res, err)
+	}
+	defer Es.Delete("test-audit", res.Hits[0].ID)
+	var rec AuditRecord
+	if err := json.Unmarshal(res.Hits[0].Source, &rec); err != nil || rec.Operation != "create" || rec.Actor != "editor" {
+		t.Errorf("wrong audit record %s", res.Hits[0].Source)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	es, err := Connect(testHost, testPort, "http", WithSoftDelete(), WithWriteOptions(Refresh(RefreshTrue)))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	ids := createSearchDocs(t)
+	defer deleteSearchDocs(t, ids)
+	query := []byte(`{"term": {"tag": "search-test"}}`)
+
+	rb, err := es.Delete("test", ids[0])
+	if err != nil || rb.Result != "updated" {
+		t.Fatalf("soft delete should update the document, got %+v %v", rb, err)
+	}
+	if _, err := es.Read("test", ids[0]); !errors.Is(err, ErrDeleted) {
+		t.Errorf("read of a deleted document should fail with ErrDeleted, got %v", err)
+	}
+	if _, err := es.Source("test", ids[0]); !errors.Is(err, ErrDeleted) {
+		t.Errorf("source of a deleted document should fail with ErrDeleted, got %v", err)
+	}
+	if ok, err := es.Exists("test", ids[0]); ok || err != nil {
+		t.Errorf("deleted document should not exist, got %v %v", ok, err)
+	}
+	if ok, _ := es.Exists("test", ids[1]); !ok {
+		t.Errorf("%s should exist", ids[1])
+	}
+	if src, err := Es.Source("test", ids[0]); err != nil || !strings.Contains(string(src), `"deleted":true`) {
+		t.Errorf("client without soft delete should see the mark, got %s %v", src, err)
+	}
+
+	res, err := es.Search("test", query)
+	if err != nil || res.Total != 2 {
+		t.Errorf("search should skip the deleted document, got %+v %v", res, err)
+	}
+	if n, err := es.Count("test", nil); err != nil {
+		t.Errorf("cannot count: %v", err)
+	} else if all, _ := Es.Count("test", nil); n != all-1 {
+		t.Errorf("count should skip the deleted document, got %d of %d", n, all)
+	}
+	page, err := es.NewPager("test", query, 10).Page("")
+	if err != nil || len(page.Hits) != 2 {
+		t.Errorf("pager should skip the deleted document, got %+v %v", page, err)
+	}
+	if _, _, err := es.UpdateDiff("test", ids[0], map[string]interface{}{"tag": "search-test"}); !errors.Is(err, ErrDeleted) {
+		t.Errorf("update diff of a deleted document should fail with ErrDeleted, got %v", err)
+	}
+
+	if _, err := es.Restore("test", ids[0]); err != nil {
+		t.Fatalf("cannot restore: %v", err)
+	}
+	if _, err := es.Read("test", ids[0]); err != nil {
+		t.Errorf("restored document should be read, got %v", err)
+	}
+	// the mark isn't part of the document for UpdateDiff
+	src, _ := es.Source("test", ids[0])
+	var doc map[string]interface{}
+	json.Unmarshal(src, &doc)
+	delete(doc, DeletedField)
+	delete(doc, DeletedAtField)
+	doc["viewed"] = 11
+	_, changes, err := es.UpdateDiff("test", ids[0], doc)
+	if err != nil || len(changes) != 1 || changes[0].Path != "viewed" {
+		t.Errorf("only viewed should change, got %+v %v", changes, err)
+	}
+	if src, _ := es.Source("test", ids[0]); !strings.Contains(string(src), `"deleted":false`) {
+		t.Errorf("the mark should be kept, got %s", src)
+	}
+
+	// bulk deletes mark the documents too
+	var bulkRecs []AuditRecord
+	bulkAudited := es.AuditTo(AuditFunc(func(ctx context.Context, rec AuditRecord) error {
+		bulkRecs = append(bulkRecs, rec)
+		return nil
+	}))
+	items, err := bulkAudited.Bulk([]byte(`{"delete": {"_index": "test", "_id": "` + ids[1] + `"}}` + "\n"))
+	if err != nil || len(items) != 1 || items[0].Action != "delete" || items[0].Result != "updated" || items[0].Error != "" {
+		t.Fatalf("bulk delete should mark the document, got %+v %v", items, err)
+	}
+	if len(bulkRecs) != 1 || bulkRecs[0].Operation != "bulk_delete" || !strings.Contains(string(bulkRecs[0].Data), DeletedAtField) {
+		t.Errorf("bulk soft delete should be audited with the mark, got %+v", bulkRecs)
+	}
+	if src, err := Es.Source("test", ids[1]); err != nil || !strings.Contains(string(src), `"deleted":true`) {
+		t.Errorf("bulk delete should keep the document, got %s %v", src, err)
+	}
+	if ok, _ := es.Exists("test", ids[1]); ok {
+		t.Errorf("bulk deleted document should not exist")
+	}
+	// the marked document still holds the id
+	if err := es.Create("test", ids[1], []byte(`{}`)); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("create over a marked document should fail with ErrAlreadyExists, got %v", err)
+	}
+	if _, err := es.Restore("test", ids[1]); err != nil {
+		t.Fatalf("cannot restore: %v", err)
+	}
+	if err := es.BulkCreate([]byte(`{"delete": {"_index": "test", "_id": "` + ids[1] + `"}}` + "\n")); err != nil {
+		t.Fatalf("cannot bulk delete: %v", err)
+	}
+	if _, err := es.Read("test", ids[1]); !errors.Is(err, ErrDeleted) {
+		t.Errorf("bulk create delete should mark the document, got %v", err)
+	}
+	if _, err := es.Restore("test", ids[1]); err != nil {
+		t.Fatalf("cannot restore: %v", err)
+	}
+
+	if _, err := es.Delete("test", ids[0]); err != nil {
+		t.Fatalf("cannot delete: %v", err)
+	}
+	if n, err := es.Purge("test", time.Hour); err != nil || n != 0 {
+		t.Errorf("purge should keep documents deleted within the retention, got %d %v", n, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	var recs []AuditRecord
+	audited := es.AuditTo(AuditFunc(func(ctx context.Context, rec AuditRecord) error {
+		recs = append(recs, rec)
+		return nil
+	}))
+	if n, err := audited.Purge("test", time.Millisecond); err != nil || n != 1 {
+		t.Errorf("purge should delete the document, got %d %v", n, err)
+	}
+	if len(recs) != 1 || recs[0].Operation != "purge" || recs[0].Count != 1 || recs[0].Result != "deleted" || !strings.Contains(string(recs[0].Data), DeletedAtField) {
+		t.Errorf("purge should be audited, got %+v", recs)
+	}
+	if ok, _ := Es.Exists("test", ids[0]); ok {
+		t.Errorf("purged document should be gone")
+	}
+	// deleteSearchDocs deletes it again
+	if err := Es.Create("test", ids[0], []byte(`{}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+}
+
+// bodyRecorder remembers the headers and bodies of the requests it passes to the Transport
+type bodyRecorder struct {
+	Transport
+	headers []http.Header
+	bodies  []string
+}
+
+func (b *bodyRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	b.headers = append(b.headers, req.Header.Clone())
+	b.bodies = append(b.bodies, string(body))
+	return b.Transport.RoundTrip(req)
+}
+
+func TestInterceptors(t *testing.T) {
+	var trace []string
+	var calls []Call
+	logging := InterceptorFunc(func(call *Call, next Handler) (*http.Response, error) {
+		trace = append(trace, "log>")
+		calls = append(calls, *call)
+		res, err := next(call)
+		trace = append(trace, "<log")
+		return res, err
+	})
+	// sends the writes twice, the way a retrying interceptor would
+	retry := InterceptorFunc(func(call *Call, next Handler) (*http.Response, error) {
+		trace = append(trace, "retry>")
+		defer func() { trace = append(trace, "<retry") }()
+		call.Request.Header.Set("X-Tenant", "rg")
+		if call.Operation == "update" {
+			res, err := next(call)
+			if err != nil {
+				return nil, err
+			}
+			res.Body.Close()
+		}
+		return next(call)
+	})
+	deny := InterceptorFunc(func(call *Call, next Handler) (*http.Response, error) {
+		if call.Operation == "delete" {
+			return nil, errors.New("delete is denied")
+		}
+		return next(call)
+	})
+
+	rec := &bodyRecorder{}
+	es, err := Connect(testHost, testPort, "http", WithTransport(rec), WithInterceptors(logging, retry), WithInterceptors(deny))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	if len(calls) != 1 || calls[0].Operation != "" {
+		t.Errorf("info request of Connect should have no operation, got %+v", calls)
+	}
+	trace, calls, rec.bodies, rec.headers = nil, nil, nil, nil
+
+	id := "intercept-asdfasdfasdf"
+	if err := es.Create("test", id, []byte(`{"title": "a"}`)); err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer Es.Delete("test", id)
+	if fmt.Sprint(trace) != "[log> retry> <retry <log]" {
+		t.Errorf("interceptors should run in order, got %v", trace)
+	}
+	if calls[0].Operation != "create" || calls[0].Index != "test" || calls[0].ID != id || string(calls[0].Body) != `{"title": "a"}` {
+		t.Errorf("wrong call %+v", calls[0])
+	}
+	if rec.headers[0].Get("X-Tenant") != "rg" {
+		t.Errorf("header set by the interceptor should be sent, got %v", rec.headers[0])
+	}
+
+	if _, err := es.Update("test", id, []byte(`{"title": "b"}`)); err != nil {
+		t.Fatalf("cannot update: %v", err)
+	}
+	if len(rec.bodies) != 3 || rec.bodies[1] != rec.bodies[2] || !strings.Contains(rec.bodies[2], `"title": "b"`) {
+		t.Errorf("update should be sent twice with the body, got %q", rec.bodies)
+	}
+
+	if _, err := es.Delete("test", id); err == nil || !strings.Contains(err.Error(), "delete is denied") {
+		t.Errorf("delete should be denied, got %v", err)
+	}
+	if ok, _ := Es.Exists("test", id); !ok {
+		t.Errorf("denied delete should not reach elastic")
+	}
+
+	// the write of an audit record is a call of its own, not one of the audited write
+	sink, err := Connect(testHost, testPort, "http", WithInterceptors(logging), WithWriteOptions(Refresh(RefreshTrue)))
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	es = es.AuditTo(NewIndexAuditSink(sink, "test-audit"))
+	calls = nil
+	if _, err := es.Update("test", id, []byte(`{"title": "c"}`)); err != nil {
+		t.Fatalf("cannot update: %v", err)
+	}
+	last := calls[len(calls)-1]
+	if last.Operation != "audit" || last.Index != "test-audit" || last.ID != "" {
+		t.Errorf("audit record should be written by an audit call, got %+v", last)
+	}
+	res, err := Es.Search("test-audit", []byte(`{"term": {"id": "`+id+`"}}`))
+	if err != nil || res.Total != 1 {
+		t.Fatalf("audit index should have the record, got %+v %v", res, err)
+	}
+	Es.Delete("test-audit", res.Hits[0].ID)
+}
@@ -0,0 +1,744 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/RGRU/escrud (interfaces: Interface)
+//
+// Generated by this command:
+//
+//	mockgen -destination=escrudmock/escrudmock.go -package=escrudmock . Interface
+//
+
+// Package escrudmock is a generated GoMock package.
+package escrudmock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	escrud "github.com/RGRU/escrud"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockInterface is a mock of Interface interface.
+type MockInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockInterfaceMockRecorder
+}
+
+// MockInterfaceMockRecorder is the mock recorder for MockInterface.
+type MockInterfaceMockRecorder struct {
+	mock *MockInterface
+}
+
+// NewMockInterface creates a new mock instance.
+func NewMockInterface(ctrl *gomock.Controller) *MockInterface {
+	mock := &MockInterface{ctrl: ctrl}
+	mock.recorder = &MockInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockInterface) EXPECT() *MockInterfaceMockRecorder {
+	return m.recorder
+}
+
+// AppendToStringList mocks base method.
+func (m *MockInterface) AppendToStringList(arg0, arg1, arg2, arg3 string, arg4 bool, arg5 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4}
+	for _, a := range arg5 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AppendToStringList", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AppendToStringList indicates an expected call of AppendToStringList.
+func (mr *MockInterfaceMockRecorder) AppendToStringList(arg0, arg1, arg2, arg3, arg4 any, arg5 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendToStringList", reflect.TypeOf((*MockInterface)(nil).AppendToStringList), varargs...)
+}
+
+// Bulk mocks base method.
+func (m *MockInterface) Bulk(arg0 []byte, arg1 ...escrud.WriteOption) ([]escrud.BulkItemResult, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Bulk", varargs...)
+	ret0, _ := ret[0].([]escrud.BulkItemResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Bulk indicates an expected call of Bulk.
+func (mr *MockInterfaceMockRecorder) Bulk(arg0 any, arg1 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Bulk", reflect.TypeOf((*MockInterface)(nil).Bulk), varargs...)
+}
+
+// BulkCreate mocks base method.
+func (m *MockInterface) BulkCreate(arg0 []byte, arg1 ...escrud.WriteOption) error {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "BulkCreate", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BulkCreate indicates an expected call of BulkCreate.
+func (mr *MockInterfaceMockRecorder) BulkCreate(arg0 any, arg1 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BulkCreate", reflect.TypeOf((*MockInterface)(nil).BulkCreate), varargs...)
+}
+
+// CapArray mocks base method.
+func (m *MockInterface) CapArray(arg0, arg1, arg2 string, arg3 int, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "CapArray", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CapArray indicates an expected call of CapArray.
+func (mr *MockInterfaceMockRecorder) CapArray(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CapArray", reflect.TypeOf((*MockInterface)(nil).CapArray), varargs...)
+}
+
+// Count mocks base method.
+func (m *MockInterface) Count(arg0 string, arg1 []byte) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Count", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Count indicates an expected call of Count.
+func (mr *MockInterfaceMockRecorder) Count(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Count", reflect.TypeOf((*MockInterface)(nil).Count), arg0, arg1)
+}
+
+// Create mocks base method.
+func (m *MockInterface) Create(arg0, arg1 string, arg2 []byte, arg3 ...escrud.WriteOption) error {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Create", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockInterfaceMockRecorder) Create(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockInterface)(nil).Create), varargs...)
+}
+
+// CreateAuto mocks base method.
+func (m *MockInterface) CreateAuto(arg0 string, arg1 []byte, arg2 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "CreateAuto", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateAuto indicates an expected call of CreateAuto.
+func (mr *MockInterfaceMockRecorder) CreateAuto(arg0, arg1 any, arg2 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAuto", reflect.TypeOf((*MockInterface)(nil).CreateAuto), varargs...)
+}
+
+// DecrementField mocks base method.
+func (m *MockInterface) DecrementField(arg0, arg1, arg2 string, arg3, arg4 int, arg5 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4}
+	for _, a := range arg5 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "DecrementField", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecrementField indicates an expected call of DecrementField.
+func (mr *MockInterfaceMockRecorder) DecrementField(arg0, arg1, arg2, arg3, arg4 any, arg5 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecrementField", reflect.TypeOf((*MockInterface)(nil).DecrementField), varargs...)
+}
+
+// Delete mocks base method.
+func (m *MockInterface) Delete(arg0, arg1 string, arg2 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Delete", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockInterfaceMockRecorder) Delete(arg0, arg1 any, arg2 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockInterface)(nil).Delete), varargs...)
+}
+
+// Exists mocks base method.
+func (m *MockInterface) Exists(arg0, arg1 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Exists", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Exists indicates an expected call of Exists.
+func (mr *MockInterfaceMockRecorder) Exists(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exists", reflect.TypeOf((*MockInterface)(nil).Exists), arg0, arg1)
+}
+
+// ExistsByQuery mocks base method.
+func (m *MockInterface) ExistsByQuery(arg0 string, arg1 []byte) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExistsByQuery", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExistsByQuery indicates an expected call of ExistsByQuery.
+func (mr *MockInterfaceMockRecorder) ExistsByQuery(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExistsByQuery", reflect.TypeOf((*MockInterface)(nil).ExistsByQuery), arg0, arg1)
+}
+
+// IncrementField mocks base method.
+func (m *MockInterface) IncrementField(arg0, arg1, arg2 string, arg3 int, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "IncrementField", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IncrementField indicates an expected call of IncrementField.
+func (mr *MockInterfaceMockRecorder) IncrementField(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementField", reflect.TypeOf((*MockInterface)(nil).IncrementField), varargs...)
+}
+
+// IncrementFloatField mocks base method.
+func (m *MockInterface) IncrementFloatField(arg0, arg1, arg2 string, arg3 float64, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "IncrementFloatField", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IncrementFloatField indicates an expected call of IncrementFloatField.
+func (mr *MockInterfaceMockRecorder) IncrementFloatField(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementFloatField", reflect.TypeOf((*MockInterface)(nil).IncrementFloatField), varargs...)
+}
+
+// Index mocks base method.
+func (m *MockInterface) Index(arg0, arg1 string, arg2 []byte, arg3 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Index", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Index indicates an expected call of Index.
+func (mr *MockInterfaceMockRecorder) Index(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Index", reflect.TypeOf((*MockInterface)(nil).Index), varargs...)
+}
+
+// InsertArrayItem mocks base method.
+func (m *MockInterface) InsertArrayItem(arg0, arg1, arg2 string, arg3 []byte, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "InsertArrayItem", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertArrayItem indicates an expected call of InsertArrayItem.
+func (mr *MockInterfaceMockRecorder) InsertArrayItem(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertArrayItem", reflect.TypeOf((*MockInterface)(nil).InsertArrayItem), varargs...)
+}
+
+// InsertArrayItemAt mocks base method.
+func (m *MockInterface) InsertArrayItemAt(arg0, arg1, arg2 string, arg3 int, arg4 []byte, arg5 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4}
+	for _, a := range arg5 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "InsertArrayItemAt", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertArrayItemAt indicates an expected call of InsertArrayItemAt.
+func (mr *MockInterfaceMockRecorder) InsertArrayItemAt(arg0, arg1, arg2, arg3, arg4 any, arg5 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertArrayItemAt", reflect.TypeOf((*MockInterface)(nil).InsertArrayItemAt), varargs...)
+}
+
+// InsertArrayItemIfAbsent mocks base method.
+func (m *MockInterface) InsertArrayItemIfAbsent(arg0, arg1, arg2, arg3 string, arg4 []byte, arg5 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4}
+	for _, a := range arg5 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "InsertArrayItemIfAbsent", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertArrayItemIfAbsent indicates an expected call of InsertArrayItemIfAbsent.
+func (mr *MockInterfaceMockRecorder) InsertArrayItemIfAbsent(arg0, arg1, arg2, arg3, arg4 any, arg5 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertArrayItemIfAbsent", reflect.TypeOf((*MockInterface)(nil).InsertArrayItemIfAbsent), varargs...)
+}
+
+// InsertArrayItems mocks base method.
+func (m *MockInterface) InsertArrayItems(arg0, arg1, arg2 string, arg3 [][]byte, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "InsertArrayItems", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertArrayItems indicates an expected call of InsertArrayItems.
+func (mr *MockInterfaceMockRecorder) InsertArrayItems(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertArrayItems", reflect.TypeOf((*MockInterface)(nil).InsertArrayItems), varargs...)
+}
+
+// MoveArrayItem mocks base method.
+func (m *MockInterface) MoveArrayItem(arg0, arg1, arg2, arg3 string, arg4, arg5 int, arg6 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4, arg5}
+	for _, a := range arg6 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "MoveArrayItem", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MoveArrayItem indicates an expected call of MoveArrayItem.
+func (mr *MockInterfaceMockRecorder) MoveArrayItem(arg0, arg1, arg2, arg3, arg4, arg5 any, arg6 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4, arg5}, arg6...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MoveArrayItem", reflect.TypeOf((*MockInterface)(nil).MoveArrayItem), varargs...)
+}
+
+// MultiSearch mocks base method.
+func (m *MockInterface) MultiSearch(arg0 []escrud.SearchRequest) ([]escrud.MultiSearchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MultiSearch", arg0)
+	ret0, _ := ret[0].([]escrud.MultiSearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MultiSearch indicates an expected call of MultiSearch.
+func (mr *MockInterfaceMockRecorder) MultiSearch(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MultiSearch", reflect.TypeOf((*MockInterface)(nil).MultiSearch), arg0)
+}
+
+// PatchArrayItems mocks base method.
+func (m *MockInterface) PatchArrayItems(arg0, arg1, arg2 string, arg3 map[string]any, arg4 []byte, arg5 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4}
+	for _, a := range arg5 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PatchArrayItems", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PatchArrayItems indicates an expected call of PatchArrayItems.
+func (mr *MockInterfaceMockRecorder) PatchArrayItems(arg0, arg1, arg2, arg3, arg4 any, arg5 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchArrayItems", reflect.TypeOf((*MockInterface)(nil).PatchArrayItems), varargs...)
+}
+
+// Read mocks base method.
+func (m *MockInterface) Read(arg0, arg1 string) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Read", arg0, arg1)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Read indicates an expected call of Read.
+func (mr *MockInterfaceMockRecorder) Read(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockInterface)(nil).Read), arg0, arg1)
+}
+
+// RemoveArrayItem mocks base method.
+func (m *MockInterface) RemoveArrayItem(arg0, arg1, arg2, arg3 string, arg4 int, arg5 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4}
+	for _, a := range arg5 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RemoveArrayItem", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveArrayItem indicates an expected call of RemoveArrayItem.
+func (mr *MockInterfaceMockRecorder) RemoveArrayItem(arg0, arg1, arg2, arg3, arg4 any, arg5 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveArrayItem", reflect.TypeOf((*MockInterface)(nil).RemoveArrayItem), varargs...)
+}
+
+// RemoveField mocks base method.
+func (m *MockInterface) RemoveField(arg0, arg1, arg2 string, arg3 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RemoveField", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveField indicates an expected call of RemoveField.
+func (mr *MockInterfaceMockRecorder) RemoveField(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveField", reflect.TypeOf((*MockInterface)(nil).RemoveField), varargs...)
+}
+
+// RenameField mocks base method.
+func (m *MockInterface) RenameField(arg0, arg1, arg2, arg3 string, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RenameField", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RenameField indicates an expected call of RenameField.
+func (mr *MockInterfaceMockRecorder) RenameField(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameField", reflect.TypeOf((*MockInterface)(nil).RenameField), varargs...)
+}
+
+// Restore mocks base method.
+func (m *MockInterface) Restore(arg0, arg1 string, arg2 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Restore", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Restore indicates an expected call of Restore.
+func (mr *MockInterfaceMockRecorder) Restore(arg0, arg1 any, arg2 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockInterface)(nil).Restore), varargs...)
+}
+
+// Search mocks base method.
+func (m *MockInterface) Search(arg0 string, arg1 []byte, arg2 ...escrud.SearchOption) (*escrud.SearchResult, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Search", varargs...)
+	ret0, _ := ret[0].(*escrud.SearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockInterfaceMockRecorder) Search(arg0, arg1 any, arg2 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockInterface)(nil).Search), varargs...)
+}
+
+// SetFieldIfAbsent mocks base method.
+func (m *MockInterface) SetFieldIfAbsent(arg0, arg1, arg2 string, arg3 []byte, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SetFieldIfAbsent", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetFieldIfAbsent indicates an expected call of SetFieldIfAbsent.
+func (mr *MockInterfaceMockRecorder) SetFieldIfAbsent(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFieldIfAbsent", reflect.TypeOf((*MockInterface)(nil).SetFieldIfAbsent), varargs...)
+}
+
+// SetFieldMax mocks base method.
+func (m *MockInterface) SetFieldMax(arg0, arg1, arg2 string, arg3 float64, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SetFieldMax", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetFieldMax indicates an expected call of SetFieldMax.
+func (mr *MockInterfaceMockRecorder) SetFieldMax(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFieldMax", reflect.TypeOf((*MockInterface)(nil).SetFieldMax), varargs...)
+}
+
+// SetFieldMin mocks base method.
+func (m *MockInterface) SetFieldMin(arg0, arg1, arg2 string, arg3 float64, arg4 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SetFieldMin", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetFieldMin indicates an expected call of SetFieldMin.
+func (mr *MockInterfaceMockRecorder) SetFieldMin(arg0, arg1, arg2, arg3 any, arg4 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFieldMin", reflect.TypeOf((*MockInterface)(nil).SetFieldMin), varargs...)
+}
+
+// SortArrayItems mocks base method.
+func (m *MockInterface) SortArrayItems(arg0, arg1, arg2, arg3 string, arg4 bool, arg5 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4}
+	for _, a := range arg5 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SortArrayItems", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SortArrayItems indicates an expected call of SortArrayItems.
+func (mr *MockInterfaceMockRecorder) SortArrayItems(arg0, arg1, arg2, arg3, arg4 any, arg5 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SortArrayItems", reflect.TypeOf((*MockInterface)(nil).SortArrayItems), varargs...)
+}
+
+// Source mocks base method.
+func (m *MockInterface) Source(arg0, arg1 string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Source", arg0, arg1)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Source indicates an expected call of Source.
+func (mr *MockInterfaceMockRecorder) Source(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Source", reflect.TypeOf((*MockInterface)(nil).Source), arg0, arg1)
+}
+
+// ToggleField mocks base method.
+func (m *MockInterface) ToggleField(arg0, arg1, arg2 string, arg3 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ToggleField", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ToggleField indicates an expected call of ToggleField.
+func (mr *MockInterfaceMockRecorder) ToggleField(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ToggleField", reflect.TypeOf((*MockInterface)(nil).ToggleField), varargs...)
+}
+
+// Update mocks base method.
+func (m *MockInterface) Update(arg0, arg1 string, arg2 []byte, arg3 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Update", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockInterfaceMockRecorder) Update(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockInterface)(nil).Update), varargs...)
+}
+
+// UpdateArrayItem mocks base method.
+func (m *MockInterface) UpdateArrayItem(arg0, arg1, arg2, arg3 string, arg4 int, arg5 []byte, arg6 ...escrud.WriteOption) (*escrud.ResponseBody, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2, arg3, arg4, arg5}
+	for _, a := range arg6 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UpdateArrayItem", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateArrayItem indicates an expected call of UpdateArrayItem.
+func (mr *MockInterfaceMockRecorder) UpdateArrayItem(arg0, arg1, arg2, arg3, arg4, arg5 any, arg6 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2, arg3, arg4, arg5}, arg6...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateArrayItem", reflect.TypeOf((*MockInterface)(nil).UpdateArrayItem), varargs...)
+}
+
+// UpdateDiff mocks base method.
+func (m *MockInterface) UpdateDiff(arg0, arg1 string, arg2 any, arg3 ...escrud.WriteOption) (*escrud.ResponseBody, []escrud.Change, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UpdateDiff", varargs...)
+	ret0, _ := ret[0].(*escrud.ResponseBody)
+	ret1, _ := ret[1].([]escrud.Change)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// UpdateDiff indicates an expected call of UpdateDiff.
+func (mr *MockInterfaceMockRecorder) UpdateDiff(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDiff", reflect.TypeOf((*MockInterface)(nil).UpdateDiff), varargs...)
+}
+
+// WithContext mocks base method.
+func (m *MockInterface) WithContext(arg0 context.Context) escrud.Interface {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithContext", arg0)
+	ret0, _ := ret[0].(escrud.Interface)
+	return ret0
+}
+
+// WithContext indicates an expected call of WithContext.
+func (mr *MockInterfaceMockRecorder) WithContext(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithContext", reflect.TypeOf((*MockInterface)(nil).WithContext), arg0)
+}
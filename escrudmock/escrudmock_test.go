@@ -0,0 +1,22 @@
+package escrudmock
+
+import (
+	"testing"
+
+	"github.com/RGRU/escrud"
+	"go.uber.org/mock/gomock"
+)
+
+var _ escrud.Interface = (*MockInterface)(nil)
+
+func TestMockInterface(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	es := NewMockInterface(ctrl)
+
+	es.EXPECT().Exists("test", "1").Return(true, nil)
+
+	var crud escrud.Interface = es
+	if ok, err := crud.Exists("test", "1"); !ok || err != nil {
+		t.Errorf("should exist: %v", err)
+	}
+}
@@ -0,0 +1,464 @@
+package escrudtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aggDoc is a document an aggregation runs on: a search hit or,
+// inside a nested aggregation, one of its nested objects
+type aggDoc struct {
+	hit hit
+	src map[string]interface{}
+}
+
+// aggregate supports terms, histogram, date_histogram, range, avg, sum, min, max,
+// value_count, cardinality, top_hits and nested aggregations with sub-aggregations
+func aggregate(raw json.RawMessage, docs []aggDoc) (obj, error) {
+	var aggs map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &aggs); err != nil {
+		return nil, err
+	}
+
+	out := obj{}
+	for name, def := range aggs {
+		var typ string
+		var body, subs json.RawMessage
+		for k, v := range def {
+			switch k {
+			case "aggs", "aggregations":
+				subs = v
+			case "meta":
+			default:
+				typ, body = k, v
+			}
+		}
+		res, err := aggregateOne(typ, body, subs, docs)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation [%s]: %v", name, err)
+		}
+		out[name] = res
+	}
+	return out, nil
+}
+
+func aggregateOne(typ string, body, subs json.RawMessage, docs []aggDoc) (obj, error) {
+	var p map[string]interface{}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	field, _ := p["field"].(string)
+
+	switch typ {
+	case "terms":
+		return termsAgg(field, p, subs, docs)
+
+	case "histogram":
+		interval, _ := p["interval"].(float64)
+		if interval <= 0 {
+			return nil, fmt.Errorf("[interval] must be >0")
+		}
+		return histogramAgg(field, subs, docs, func(v interface{}) (float64, bool) {
+			f, ok := v.(float64)
+			return math.Floor(f/interval) * interval, ok
+		}, func(key float64) float64 {
+			return key + interval
+		}, nil)
+
+	case "date_histogram":
+		floor, next, err := dateInterval(p)
+		if err != nil {
+			return nil, err
+		}
+		return histogramAgg(field, subs, docs, func(v interface{}) (float64, bool) {
+			t, ok := parseDate(v)
+			return float64(floor(t).UnixNano() / int64(time.Millisecond)), ok
+		}, func(key float64) float64 {
+			return float64(next(msTime(key)).UnixNano() / int64(time.Millisecond))
+		}, func(key float64) string {
+			return msTime(key).Format("2006-01-02T15:04:05.000Z")
+		})
+
+	case "range":
+		return rangeAgg(field, p, subs, docs)
+
+	case "avg", "sum", "min", "max", "value_count", "cardinality":
+		return metricAgg(typ, field, docs), nil
+
+	case "top_hits":
+		return topHitsAgg(p, body, docs)
+
+	case "nested":
+		path, _ := p["path"].(string)
+		var nested []aggDoc
+		for _, d := range docs {
+			var items []interface{}
+			switch v := getPath(d.src, path).(type) {
+			case []interface{}:
+				items = v
+			case map[string]interface{}:
+				items = []interface{}{v}
+			}
+			for _, it := range items {
+				m, ok := it.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				src := map[string]interface{}{}
+				setPath(src, path, m)
+				nested = append(nested, aggDoc{hit: d.hit, src: src})
+			}
+		}
+		return bucket(obj{"doc_count": len(nested)}, subs, nested)
+	}
+
+	return nil, fmt.Errorf("escrudtest does not support [%s] aggregation", typ)
+}
+
+// bucket adds the sub-aggregations of docs to b
+func bucket(b obj, subs json.RawMessage, docs []aggDoc) (obj, error) {
+	if len(subs) == 0 {
+		return b, nil
+	}
+	res, err := aggregate(subs, docs)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range res {
+		b[k] = v
+	}
+	return b, nil
+}
+
+// values returns the values of the field in the document, arrays are flattened
+func values(d aggDoc, field string) []interface{} {
+	switch v := getPath(d.src, field).(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+func termsAgg(field string, p map[string]interface{}, subs json.RawMessage, docs []aggDoc) (obj, error) {
+	size := 10
+	if v, ok := p["size"].(float64); ok {
+		size = int(v)
+	}
+
+	type group struct {
+		key  interface{}
+		docs []aggDoc
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+	for _, d := range docs {
+		seen := map[string]bool{}
+		for _, v := range values(d, field) {
+			k := fmt.Sprint(v)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			g, ok := byKey[k]
+			if !ok {
+				g = &group{key: v}
+				byKey[k] = g
+				groups = append(groups, g)
+			}
+			g.docs = append(g.docs, d)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].docs) != len(groups[j].docs) {
+			return len(groups[i].docs) > len(groups[j].docs)
+		}
+		c, _ := compare(groups[i].key, groups[j].key)
+		return c < 0
+	})
+
+	other := 0
+	if len(groups) > size {
+		for _, g := range groups[size:] {
+			other += len(g.docs)
+		}
+		groups = groups[:size]
+	}
+
+	buckets := make([]obj, 0, len(groups))
+	for _, g := range groups {
+		b, err := bucket(obj{"key": g.key, "doc_count": len(g.docs)}, subs, g.docs)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return obj{"doc_count_error_upper_bound": 0, "sum_other_doc_count": other, "buckets": buckets}, nil
+}
+
+// histogramAgg groups docs by the key of their values and fills the gaps between the first and the last keys
+func histogramAgg(field string, subs json.RawMessage, docs []aggDoc,
+	key func(interface{}) (float64, bool), next func(float64) float64, format func(float64) string) (obj, error) {
+
+	groups := map[float64][]aggDoc{}
+	first, last := math.Inf(1), math.Inf(-1)
+	for _, d := range docs {
+		for _, v := range values(d, field) {
+			k, ok := key(v)
+			if !ok {
+				continue
+			}
+			groups[k] = append(groups[k], d)
+			first, last = math.Min(first, k), math.Max(last, k)
+		}
+	}
+
+	buckets := []obj{}
+	for k := first; k <= last; k = next(k) {
+		b := obj{"key": k, "doc_count": len(groups[k])}
+		if format != nil {
+			b["key_as_string"] = format(k)
+		}
+		b, err := bucket(b, subs, groups[k])
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return obj{"buckets": buckets}, nil
+}
+
+func msTime(ms float64) time.Time {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC()
+}
+
+// parseDate accepts epoch milliseconds and the usual date formats
+func parseDate(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case float64:
+		return msTime(t), true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+			if d, err := time.Parse(layout, t); err == nil {
+				return d.UTC(), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// dateInterval returns the functions truncating a time to its bucket and moving to the next bucket
+func dateInterval(p map[string]interface{}) (floor, next func(time.Time) time.Time, err error) {
+	if s, ok := p["calendar_interval"].(string); ok {
+		unit := strings.TrimPrefix(s, "1")
+		switch unit {
+		case "m", "minute":
+			return truncate(time.Minute), add(time.Minute), nil
+		case "h", "hour":
+			return truncate(time.Hour), add(time.Hour), nil
+		case "d", "day":
+			return truncate(24 * time.Hour), add(24 * time.Hour), nil
+		case "w", "week":
+			return func(t time.Time) time.Time {
+				d := t.Truncate(24 * time.Hour)
+				return d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+			}, add(7 * 24 * time.Hour), nil
+		case "M", "month":
+			return func(t time.Time) time.Time {
+				return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+			}, addDate(0, 1), nil
+		case "q", "quarter":
+			return func(t time.Time) time.Time {
+				return time.Date(t.Year(), (t.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+			}, addDate(0, 3), nil
+		case "y", "year":
+			return func(t time.Time) time.Time {
+				return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+			}, addDate(1, 0), nil
+		}
+		return nil, nil, fmt.Errorf("unknown calendar_interval [%s]", s)
+	}
+
+	s, ok := p["fixed_interval"].(string)
+	if !ok {
+		s, _ = p["interval"].(string)
+	}
+	d, err := fixedInterval(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return truncate(d), add(d), nil
+}
+
+func fixedInterval(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ms": time.Millisecond, "s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour,
+	}
+	for _, suffix := range []string{"ms", "s", "m", "h", "d"} {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+		if err != nil || n <= 0 {
+			break
+		}
+		return time.Duration(n) * units[suffix], nil
+	}
+	return 0, fmt.Errorf("bad fixed_interval [%s]", s)
+}
+
+func truncate(d time.Duration) func(time.Time) time.Time {
+	return func(t time.Time) time.Time { return t.Truncate(d) }
+}
+
+func add(d time.Duration) func(time.Time) time.Time {
+	return func(t time.Time) time.Time { return t.Add(d) }
+}
+
+func addDate(years, months int) func(time.Time) time.Time {
+	return func(t time.Time) time.Time { return t.AddDate(years, months, 0) }
+}
+
+func rangeAgg(field string, p map[string]interface{}, subs json.RawMessage, docs []aggDoc) (obj, error) {
+	ranges, _ := p["ranges"].([]interface{})
+	buckets := make([]obj, 0, len(ranges))
+	for _, r := range ranges {
+		r := item(r)
+		from, hasFrom := r["from"].(float64)
+		to, hasTo := r["to"].(float64)
+
+		key, ok := r["key"].(string)
+		if !ok {
+			key = "*-"
+			if hasFrom {
+				key = formatFloat(from) + "-"
+			}
+			if hasTo {
+				key += formatFloat(to)
+			} else {
+				key += "*"
+			}
+		}
+
+		var in []aggDoc
+		for _, d := range docs {
+			for _, v := range values(d, field) {
+				f, ok := v.(float64)
+				if ok && (!hasFrom || f >= from) && (!hasTo || f < to) {
+					in = append(in, d)
+					break
+				}
+			}
+		}
+
+		b := obj{"key": key, "doc_count": len(in)}
+		if hasFrom {
+			b["from"] = from
+		}
+		if hasTo {
+			b["to"] = to
+		}
+		b, err := bucket(b, subs, in)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return obj{"buckets": buckets}, nil
+}
+
+// formatFloat formats range keys as elastic does: 10 is "10.0"
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+func metricAgg(typ, field string, docs []aggDoc) obj {
+	var nums []float64
+	distinct := map[string]bool{}
+	count := 0
+	for _, d := range docs {
+		for _, v := range values(d, field) {
+			count++
+			distinct[fmt.Sprint(v)] = true
+			if f, ok := v.(float64); ok {
+				nums = append(nums, f)
+			}
+		}
+	}
+
+	switch typ {
+	case "value_count":
+		return obj{"value": count}
+	case "cardinality":
+		return obj{"value": len(distinct)}
+	case "sum":
+		sum := 0.0
+		for _, f := range nums {
+			sum += f
+		}
+		return obj{"value": sum}
+	}
+
+	if len(nums) == 0 {
+		return obj{"value": nil}
+	}
+	res := nums[0]
+	for _, f := range nums[1:] {
+		switch typ {
+		case "avg":
+			res += f
+		case "min":
+			res = math.Min(res, f)
+		case "max":
+			res = math.Max(res, f)
+		}
+	}
+	if typ == "avg" {
+		res /= float64(len(nums))
+	}
+	return obj{"value": res}
+}
+
+func topHitsAgg(p map[string]interface{}, body json.RawMessage, docs []aggDoc) (obj, error) {
+	var req struct {
+		Sort json.RawMessage `json:"sort"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	size := 3
+	if v, ok := p["size"].(float64); ok {
+		size = int(v)
+	}
+
+	var hits []hit
+	seen := map[*document]bool{}
+	for _, d := range docs {
+		if !seen[d.hit.doc] {
+			seen[d.hit.doc] = true
+			hits = append(hits, d.hit)
+		}
+	}
+	sortFields, err := parseSort(req.Sort)
+	if err != nil {
+		return nil, err
+	}
+	sortHits(hits, sortFields)
+	total := len(hits)
+	if len(hits) > size {
+		hits = hits[:size]
+	}
+
+	return obj{"hits": hitsObj(hits, total, nil)}, nil
+}
@@ -0,0 +1,536 @@
+// Package escrudtest provides an in-memory fake of the Elastic Search HTTP API,
+// enough to test escrud and the code built on it without a cluster.
+//
+//	srv := escrudtest.NewServer()
+//	defer srv.Close()
+//	es, err := escrud.Connect(srv.Host(), srv.Port(), "http")
+//
+// It understands index, create, get, exists, source, update (partial doc and the
+// scripts sent by escrud), delete, _bulk, _count, _msearch, _delete_by_query and
+// a basic subset of _search with aggregations, highlighting and suggesters.
+package escrudtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a fake elastic node backed by memory
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	indices map[string]map[string]*document
+	seqNo   int
+	autoID  int
+	scripts []script
+}
+
+type document struct {
+	source  map[string]interface{}
+	version int
+	seqNo   int
+}
+
+// NewServer starts a fake server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		indices: map[string]map[string]*document{},
+		scripts: append([]script(nil), builtinScripts...),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Host of the server, as expected by escrud.Connect
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Listener.Addr().String())
+	return host
+}
+
+// Port of the server, as expected by escrud.Connect
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// Reset drops all the indices
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indices = map[string]map[string]*document{}
+}
+
+// Source returns the stored source of the document, if any
+func (s *Server) Source(index, id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.indices[index][id]
+	if !ok {
+		return nil, false
+	}
+	b, _ := json.Marshal(doc.source)
+	return b, true
+}
+
+// Len returns the number of documents in the index
+func (s *Server) Len(index string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.indices[index])
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+
+	var parts []string
+	for _, p := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		if p == "" {
+			continue
+		}
+		u, err := url.PathUnescape(p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+		parts = append(parts, u)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, resp := s.route(r.Method, parts, r.URL.Query(), body)
+	if resp == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (s *Server) route(method string, parts []string, query url.Values, body []byte) (int, interface{}) {
+	switch {
+	case len(parts) == 0:
+		return http.StatusOK, obj{
+			"name":         "escrudtest",
+			"cluster_name": "escrudtest",
+			"version":      obj{"number": "7.12.0"},
+			"tagline":      "You Know, for Search",
+		}
+
+	case len(parts) == 1 && parts[0] == "_bulk":
+		return s.bulk("", body)
+
+	case len(parts) == 1 && parts[0] == "_search":
+		return s.search("", query, body)
+
+	case len(parts) == 1 && parts[0] == "_count":
+		return s.count("", body)
+
+	case len(parts) == 1 && parts[0] == "_msearch":
+		return s.msearch("", body)
+
+	case len(parts) == 1:
+		return s.indexOp(method, parts[0])
+
+	case len(parts) == 2 && parts[1] == "_bulk":
+		return s.bulk(parts[0], body)
+
+	case len(parts) == 2 && parts[1] == "_search":
+		return s.search(parts[0], query, body)
+
+	case len(parts) == 2 && parts[1] == "_count":
+		return s.count(parts[0], body)
+
+	case len(parts) == 2 && parts[1] == "_msearch":
+		return s.msearch(parts[0], body)
+
+	case len(parts) == 2 && parts[1] == "_delete_by_query" && method == http.MethodPost:
+		return s.deleteByQuery(parts[0], body)
+
+	case len(parts) == 2 && parts[1] == "_doc" && method == http.MethodPost:
+		src, err := decodeSource(body)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+		}
+		return s.index(parts[0], s.nextID(), src, "create")
+
+	case len(parts) == 3:
+		return s.docOp(method, parts[0], parts[1], parts[2], query, body)
+
+	case len(parts) == 4 && strings.HasPrefix(parts[3], "_"):
+		// the typed form /{index}/_doc/{id}/_update used by the go client
+		return s.docOp(method, parts[0], parts[3], parts[2], query, body)
+	}
+
+	return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+		fmt.Sprintf("no handler found for uri [/%s] and method [%s]", strings.Join(parts, "/"), method))
+}
+
+func (s *Server) indexOp(method, index string) (int, interface{}) {
+	switch method {
+	case http.MethodHead:
+		if _, ok := s.indices[index]; ok {
+			return http.StatusOK, nil
+		}
+		return http.StatusNotFound, nil
+	case http.MethodPut:
+		if _, ok := s.indices[index]; !ok {
+			s.indices[index] = map[string]*document{}
+		}
+		return http.StatusOK, obj{"acknowledged": true, "index": index}
+	case http.MethodDelete:
+		if _, ok := s.indices[index]; !ok {
+			return indexNotFound(index)
+		}
+		delete(s.indices, index)
+		return http.StatusOK, obj{"acknowledged": true}
+	}
+	return errorBody(http.StatusMethodNotAllowed, "illegal_argument_exception", "method not allowed")
+}
+
+func (s *Server) docOp(method, index, endpoint, id string, query url.Values, body []byte) (int, interface{}) {
+	switch {
+	case endpoint == "_doc" && (method == http.MethodPut || method == http.MethodPost),
+		endpoint == "_create" && (method == http.MethodPut || method == http.MethodPost):
+		src, err := decodeSource(body)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+		}
+		opType := query.Get("op_type")
+		if endpoint == "_create" {
+			opType = "create"
+		}
+		return s.index(index, id, src, opType)
+
+	case endpoint == "_doc" && (method == http.MethodGet || method == http.MethodHead):
+		doc, ok := s.indices[index][id]
+		if !ok {
+			return http.StatusNotFound, obj{"_index": index, "_type": "_doc", "_id": id, "found": false}
+		}
+		return http.StatusOK, obj{
+			"_index":        index,
+			"_type":         "_doc",
+			"_id":           id,
+			"_version":      doc.version,
+			"_seq_no":       doc.seqNo,
+			"_primary_term": 1,
+			"found":         true,
+			"_source":       doc.source,
+		}
+
+	case endpoint == "_source" && (method == http.MethodGet || method == http.MethodHead):
+		doc, ok := s.indices[index][id]
+		if !ok {
+			return errorBody(http.StatusNotFound, "resource_not_found_exception",
+				fmt.Sprintf("Document not found [%s]/[_doc]/[%s]", index, id))
+		}
+		return http.StatusOK, doc.source
+
+	case endpoint == "_update" && method == http.MethodPost:
+		var req updateRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errorBody(http.StatusBadRequest, "x_content_parse_exception", err.Error())
+		}
+		return s.update(index, id, req)
+
+	case endpoint == "_doc" && method == http.MethodDelete:
+		return s.delete(index, id)
+	}
+
+	return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+		fmt.Sprintf("no handler found for uri [/%s/%s/%s] and method [%s]", index, endpoint, id, method))
+}
+
+func (s *Server) nextID() string {
+	s.autoID++
+	return fmt.Sprintf("escrudtest-%d", s.autoID)
+}
+
+// index stores the document, opType "create" fails on an existing one
+func (s *Server) index(index, id string, src map[string]interface{}, opType string) (int, obj) {
+	docs, ok := s.indices[index]
+	if !ok {
+		docs = map[string]*document{}
+		s.indices[index] = docs
+	}
+
+	status, result := http.StatusCreated, "created"
+	version := 1
+	if doc, ok := docs[id]; ok {
+		if opType == "create" {
+			return errorBody(http.StatusConflict, "version_conflict_engine_exception",
+				fmt.Sprintf("[%s]: version conflict, document already exists (current version [%d])", id, doc.version))
+		}
+		status, result = http.StatusOK, "updated"
+		version = doc.version + 1
+	}
+
+	s.seqNo++
+	docs[id] = &document{source: src, version: version, seqNo: s.seqNo}
+	return status, writeResult(index, id, result, docs[id])
+}
+
+type updateRequest struct {
+	Doc         map[string]interface{} `json:"doc"`
+	DocAsUpsert bool                   `json:"doc_as_upsert"`
+	Upsert      map[string]interface{} `json:"upsert"`
+	Script      json.RawMessage        `json:"script"`
+}
+
+func (s *Server) update(index, id string, req updateRequest) (int, obj) {
+	doc, ok := s.indices[index][id]
+	if !ok {
+		switch {
+		case req.DocAsUpsert && req.Doc != nil:
+			return s.index(index, id, req.Doc, "create")
+		case req.Upsert != nil:
+			return s.index(index, id, req.Upsert, "create")
+		}
+		return errorBody(http.StatusNotFound, "document_missing_exception",
+			fmt.Sprintf("[_doc][%s]: document missing", id))
+	}
+
+	src := deepCopy(doc.source).(map[string]interface{})
+	switch {
+	case req.Doc != nil:
+		merge(src, req.Doc)
+		if equal(src, doc.source) {
+			return http.StatusOK, writeResult(index, id, "noop", doc)
+		}
+
+	case req.Script != nil:
+		source, params, err := parseScript(req.Script)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "x_content_parse_exception", err.Error())
+		}
+		if err := s.runScript(source, src, params); err != nil {
+			if err == ErrNoop {
+				return http.StatusOK, writeResult(index, id, "noop", doc)
+			}
+			return scriptError(source, err)
+		}
+
+	default:
+		return errorBody(http.StatusBadRequest, "action_request_validation_exception",
+			"Validation Failed: 1: script or doc is missing;")
+	}
+
+	s.seqNo++
+	doc.source = src
+	doc.version++
+	doc.seqNo = s.seqNo
+	return http.StatusOK, writeResult(index, id, "updated", doc)
+}
+
+func (s *Server) delete(index, id string) (int, obj) {
+	doc, ok := s.indices[index][id]
+	if !ok {
+		res := writeResult(index, id, "not_found", &document{version: 1})
+		return http.StatusNotFound, res
+	}
+	delete(s.indices[index], id)
+	s.seqNo++
+	doc.version++
+	doc.seqNo = s.seqNo
+	return http.StatusOK, writeResult(index, id, "deleted", doc)
+}
+
+// bulk runs the NDJSON actions one by one, as elastic does
+func (s *Server) bulk(index string, body []byte) (int, interface{}) {
+	var lines [][]byte
+	for _, l := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(l)) > 0 {
+			lines = append(lines, l)
+		}
+	}
+
+	type action struct {
+		name string
+		meta struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		body []byte
+	}
+
+	var actions []action
+	for i := 0; i < len(lines); i++ {
+		var head map[string]json.RawMessage
+		if err := json.Unmarshal(lines[i], &head); err != nil || len(head) != 1 {
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("Malformed action/metadata line [%d]", i+1))
+		}
+		var a action
+		for name, meta := range head {
+			a.name = name
+			if err := json.Unmarshal(meta, &a.meta); err != nil {
+				return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+					fmt.Sprintf("Malformed action/metadata line [%d]", i+1))
+			}
+		}
+		if a.meta.Index == "" {
+			a.meta.Index = index
+		}
+		if a.meta.Index == "" {
+			return errorBody(http.StatusBadRequest, "action_request_validation_exception",
+				"Validation Failed: 1: index is missing;")
+		}
+		switch a.name {
+		case "index", "create", "update":
+			i++
+			if i == len(lines) {
+				return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+					"The bulk request must be terminated by a newline [\\n]")
+			}
+			a.body = lines[i]
+		case "delete":
+		default:
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("Malformed action/metadata line [%d], expected one of [create, delete, index, update] but found [%s]", i+1, a.name))
+		}
+		actions = append(actions, a)
+	}
+
+	var items []obj
+	hasErrors := false
+	for _, a := range actions {
+		var status int
+		var res obj
+		switch a.name {
+		case "index", "create":
+			src, err := decodeSource(a.body)
+			if err != nil {
+				status, res = errorBody(http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+				break
+			}
+			id := a.meta.ID
+			if id == "" {
+				id = s.nextID()
+			}
+			opType := "index"
+			if a.name == "create" {
+				opType = "create"
+			}
+			status, res = s.index(a.meta.Index, id, src, opType)
+		case "update":
+			var req updateRequest
+			if err := json.Unmarshal(a.body, &req); err != nil {
+				status, res = errorBody(http.StatusBadRequest, "x_content_parse_exception", err.Error())
+				break
+			}
+			status, res = s.update(a.meta.Index, a.meta.ID, req)
+		case "delete":
+			status, res = s.delete(a.meta.Index, a.meta.ID)
+		}
+
+		item := obj{"_index": a.meta.Index, "_type": "_doc", "_id": a.meta.ID, "status": status}
+		if e, ok := res["error"]; ok {
+			hasErrors = true
+			item["error"] = e
+		} else {
+			for k, v := range res {
+				item[k] = v
+			}
+			item["status"] = status
+		}
+		items = append(items, obj{a.name: item})
+	}
+
+	return http.StatusOK, obj{"took": 0, "errors": hasErrors, "items": items}
+}
+
+// resolve returns the names of the indices matched by comma separated patterns,
+// an empty pattern or _all match everything
+func (s *Server) resolve(pattern string) []string {
+	var names []string
+	for name := range s.indices {
+		if matchIndex(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func matchIndex(pattern, name string) bool {
+	if pattern == "" || pattern == "_all" {
+		return true
+	}
+	for _, p := range strings.Split(pattern, ",") {
+		if p == name || p == "*" {
+			return true
+		}
+		if strings.HasSuffix(p, "*") && strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+type obj = map[string]interface{}
+
+func writeResult(index, id, result string, doc *document) obj {
+	return obj{
+		"_index":        index,
+		"_type":         "_doc",
+		"_id":           id,
+		"_version":      doc.version,
+		"result":        result,
+		"_shards":       obj{"total": 1, "successful": 1, "failed": 0},
+		"_seq_no":       doc.seqNo,
+		"_primary_term": 1,
+	}
+}
+
+func errorBody(status int, typ, reason string) (int, obj) {
+	cause := obj{"type": typ, "reason": reason}
+	return status, obj{
+		"error": obj{
+			"root_cause": []obj{cause},
+			"type":       typ,
+			"reason":     reason,
+		},
+		"status": status,
+	}
+}
+
+func indexNotFound(index string) (int, obj) {
+	return errorBody(http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", index))
+}
+
+func writeError(w http.ResponseWriter, status int, typ, reason string) {
+	_, body := errorBody(status, typ, reason)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func decodeSource(body []byte) (map[string]interface{}, error) {
+	var src map[string]interface{}
+	if err := json.Unmarshal(body, &src); err != nil {
+		return nil, fmt.Errorf("failed to parse document: %v", err)
+	}
+	if src == nil {
+		return nil, fmt.Errorf("failed to parse document: not an object")
+	}
+	return src, nil
+}
@@ -0,0 +1,117 @@
+package escrudtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func do(t *testing.T, srv *Server, method, path, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("ERR: %v", err)
+	}
+	defer res.Body.Close()
+
+	var parsed map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&parsed)
+	return res.StatusCode, parsed
+}
+
+func TestBulkSearch(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	status, res := do(t, srv, "POST", "/_bulk", `{"index":{"_index":"test","_id":"1"}}
+{"user":"slivki","views":10,"text":"Как изменилась сеть"}
+{"index":{"_index":"test","_id":"2"}}
+{"user":"barsuk","views":5,"text":"наверное, как-то изменилась."}
+{"create":{"_index":"test","_id":"1"}}
+{"user":"dup"}
+`)
+	if status != 200 || res["errors"] != true {
+		t.Fatalf("create of existing document should fail: %d %v", status, res)
+	}
+	if srv.Len("test") != 2 {
+		t.Fatalf("should be 2 documents, got %d", srv.Len("test"))
+	}
+
+	_, res = do(t, srv, "POST", "/test/_search", `{
+		"query": {"bool": {"filter": [{"range": {"views": {"gte": 1}}}], "must_not": {"term": {"user": "nobody"}}}},
+		"sort": [{"views": "asc"}],
+		"size": 1
+	}`)
+	hits := res["hits"].(map[string]interface{})
+	if total := hits["total"].(map[string]interface{})["value"]; total != 2.0 {
+		t.Errorf("total should be 2, got %v", total)
+	}
+	list := hits["hits"].([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["_id"] != "2" {
+		t.Errorf("should be the doc 2 only: %v", list)
+	}
+
+	// totals are counted up to track_total_hits, 10000 by default
+	for body, want := range map[string]string{
+		`{"track_total_hits": 1}`:    "map[relation:gte value:1]",
+		`{"track_total_hits": true}`: "map[relation:eq value:2]",
+		`{}`:                         "map[relation:eq value:2]",
+	} {
+		_, res = do(t, srv, "POST", "/test/_search", body)
+		if total := fmt.Sprint(res["hits"].(map[string]interface{})["total"]); total != want {
+			t.Errorf("total of %s should be %s, got %s", body, want, total)
+		}
+	}
+
+	_, res = do(t, srv, "POST", "/test/_search", `{"query": {"match": {"text": "сеть"}}}`)
+	list = res["hits"].(map[string]interface{})["hits"].([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["_id"] != "1" {
+		t.Errorf("should match the doc 1 only: %v", list)
+	}
+}
+
+func TestHandleScript(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.HandleScript(`ctx._source.%s = params.value`, func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+		return setPath(src, f[0], p["value"])
+	})
+
+	do(t, srv, "PUT", "/test/_doc/1", `{"user":"slivki"}`)
+	status, res := do(t, srv, "POST", "/test/_update/1", `{"script":{"source":"ctx._source.meta.user  =  params.value","params":{"value":"barsuk"}}}`)
+	if status != 200 || res["result"] != "updated" {
+		t.Fatalf("script failed: %d %v", status, res)
+	}
+
+	got, _ := srv.Source("test", "1")
+	if string(got) != `{"meta":{"user":"barsuk"},"user":"slivki"}` {
+		t.Errorf("bad source: %s", got)
+	}
+
+	status, _ = do(t, srv, "POST", "/test/_update/1", `{"script":"ctx._source.remove('user')"}`)
+	if status != 400 {
+		t.Errorf("unknown script should fail, got %d", status)
+	}
+}
+
+func TestNormalizeBody(t *testing.T) {
+	a := normalizeBody([]byte(`{
+		"user": "slivki",
+		"aim":  "test"
+	}`))
+	b := normalizeBody([]byte(`{"aim":"test","user":"slivki"}`))
+	if a != b {
+		t.Errorf("bodies should match: %s != %s", a, b)
+	}
+
+	bulk := normalizeBody([]byte("{ \"index\" : { \"_id\" : \"1\" } }\n{\"b\":1, \"a\":2}\n"))
+	if bulk != "{\"index\":{\"_id\":\"1\"}}\n{\"a\":2,\"b\":1}" {
+		t.Errorf("bad ndjson: %s", bulk)
+	}
+}
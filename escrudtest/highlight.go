@@ -0,0 +1,149 @@
+package escrudtest
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type highlightRequest struct {
+	Fields            map[string]json.RawMessage `json:"fields"`
+	PreTags           []string                   `json:"pre_tags"`
+	PostTags          []string                   `json:"post_tags"`
+	FragmentSize      *int                       `json:"fragment_size"`
+	NumberOfFragments *int                       `json:"number_of_fragments"`
+}
+
+// highlight wraps the words of the query found in the fields of the document into the tags.
+// Fragments are cut around the matches by words, not by sentences as elastic does.
+func highlight(req *highlightRequest, query json.RawMessage, src map[string]interface{}) obj {
+	terms := map[string][]string{}
+	queryTerms(query, terms)
+
+	pre, post := "<em>", "</em>"
+	if len(req.PreTags) > 0 {
+		pre = req.PreTags[0]
+	}
+	if len(req.PostTags) > 0 {
+		post = req.PostTags[0]
+	}
+	size, number := 100, 5
+	if req.FragmentSize != nil {
+		size = *req.FragmentSize
+	}
+	if req.NumberOfFragments != nil {
+		number = *req.NumberOfFragments
+	}
+
+	out := obj{}
+	for field := range req.Fields {
+		words := terms[field]
+		if len(words) == 0 {
+			continue
+		}
+		var fragments []string
+		for _, v := range values(aggDoc{src: src}, field) {
+			text, ok := v.(string)
+			if !ok {
+				continue
+			}
+			fragments = append(fragments, fragment(text, words, pre, post, size, number)...)
+		}
+		if len(fragments) > 0 {
+			out[field] = fragments
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// fragment returns the pieces of the text of about size chars with the matching words tagged,
+// the whole text if number is 0
+func fragment(text string, words []string, pre, post string, size, number int) []string {
+	tokens := strings.Fields(text)
+	matched := make([]bool, len(tokens))
+	found := false
+	for i, t := range tokens {
+		w := strings.ToLower(strings.Trim(t, ".,!?;:\"'"))
+		for _, q := range words {
+			if w == q {
+				matched[i], found = true, true
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	tag := func(from, to int) string {
+		parts := make([]string, 0, to-from)
+		for i := from; i < to; i++ {
+			t := tokens[i]
+			if matched[i] {
+				t = pre + t + post
+			}
+			parts = append(parts, t)
+		}
+		return strings.Join(parts, " ")
+	}
+	if number == 0 {
+		return []string{tag(0, len(tokens))}
+	}
+
+	var fragments []string
+	for i := 0; i < len(tokens) && len(fragments) < number; i++ {
+		if !matched[i] {
+			continue
+		}
+		// grow the fragment around the match up to size chars
+		from, to, n := i, i+1, len(tokens[i])
+		for n < size {
+			grown := false
+			if from > 0 && n+len(tokens[from-1])+1 <= size {
+				from--
+				n += len(tokens[from]) + 1
+				grown = true
+			}
+			if to < len(tokens) && n+len(tokens[to])+1 <= size {
+				n += len(tokens[to]) + 1
+				to++
+				grown = true
+			}
+			if !grown {
+				break
+			}
+		}
+		fragments = append(fragments, tag(from, to))
+		i = to - 1
+	}
+	return fragments
+}
+
+// queryTerms collects lowercased words of term, match, match_phrase and prefix queries by field
+func queryTerms(raw json.RawMessage, terms map[string][]string) {
+	var q map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &q); err != nil {
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err == nil {
+			for _, item := range list {
+				queryTerms(item, terms)
+			}
+		}
+		return
+	}
+	for typ, body := range q {
+		switch typ {
+		case "term", "match", "match_phrase", "prefix":
+			field, value, err := fieldValue(body, "value", "query")
+			if err != nil {
+				continue
+			}
+			if s, ok := value.(string); ok {
+				terms[field] = append(terms[field], strings.Fields(strings.ToLower(s))...)
+			}
+		default:
+			queryTerms(body, terms)
+		}
+	}
+}
@@ -0,0 +1,643 @@
+package escrudtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type hit struct {
+	index string
+	id    string
+	doc   *document
+}
+
+type searchRequest struct {
+	Query json.RawMessage `json:"query"`
+	From  *int            `json:"from"`
+	Size  *int            `json:"size"`
+	Sort  json.RawMessage `json:"sort"`
+	Aggs  json.RawMessage `json:"aggs"`
+
+	SearchAfter    []interface{}     `json:"search_after"`
+	TrackTotalHits json.RawMessage   `json:"track_total_hits"`
+	Highlight      *highlightRequest `json:"highlight"`
+	Suggest        json.RawMessage   `json:"suggest"`
+}
+
+// trackTotalHits is the default number of hits elastic counts exactly
+const trackTotalHits = 10000
+
+// totalLimit returns the number of hits to count exactly by track_total_hits, -1 for all of them
+func (r *searchRequest) totalLimit() int {
+	var track interface{}
+	if json.Unmarshal(r.TrackTotalHits, &track) != nil {
+		return trackTotalHits
+	}
+	switch v := track.(type) {
+	case bool:
+		if v {
+			return -1
+		}
+		return 0
+	case float64:
+		return int(v)
+	}
+	return trackTotalHits
+}
+
+// maxResultWindow is the default index.max_result_window of elastic
+const maxResultWindow = 10000
+
+// search supports match_all, ids, term, terms, match, range, exists and bool queries,
+// sorting by fields and from/size
+func (s *Server) search(index string, query url.Values, body []byte) (int, interface{}) {
+	var req searchRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errorBody(http.StatusBadRequest, "parsing_exception", err.Error())
+		}
+	}
+	if v := query.Get("from"); v != "" {
+		n, _ := strconv.Atoi(v)
+		req.From = &n
+	}
+	if v := query.Get("size"); v != "" {
+		n, _ := strconv.Atoi(v)
+		req.Size = &n
+	}
+
+	hits, err := s.match(index, req.Query)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parsing_exception", err.Error())
+	}
+	sortFields, err := parseSort(req.Sort)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parsing_exception", err.Error())
+	}
+	sortHits(hits, sortFields)
+
+	// search_after doesn't change the total
+	total := len(hits)
+	if req.SearchAfter != nil {
+		if len(req.SearchAfter) != len(sortFields) {
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				"search_after has different number of sort values than the sort")
+		}
+		after := hits[:0]
+		for _, h := range hits {
+			if compareSort(h, sortFields, req.SearchAfter) > 0 {
+				after = append(after, h)
+			}
+		}
+		hits = after
+	}
+
+	terminated := false
+	if v := query.Get("terminate_after"); v != "" {
+		n, _ := strconv.Atoi(v)
+		if n > 0 && len(hits) > n {
+			hits, terminated, total = hits[:n], true, n
+		}
+	}
+
+	var aggs obj
+	if len(req.Aggs) > 0 {
+		docs := make([]aggDoc, len(hits))
+		for i, h := range hits {
+			docs[i] = aggDoc{hit: h, src: h.doc.source}
+		}
+		if aggs, err = aggregate(req.Aggs, docs); err != nil {
+			return errorBody(http.StatusBadRequest, "aggregation_execution_exception", err.Error())
+		}
+	}
+
+	from, size := 0, 10
+	if req.From != nil {
+		from = *req.From
+	}
+	if req.Size != nil {
+		size = *req.Size
+	}
+	if from+size > maxResultWindow {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf(
+			"Result window is too large, from + size must be less than or equal to: [%d] but was [%d]", maxResultWindow, from+size))
+	}
+	if from > len(hits) {
+		from = len(hits)
+	}
+	if from+size < len(hits) {
+		hits = hits[:from+size]
+	}
+	hits = hits[from:]
+
+	section := hitsObj(hits, total, sortFields)
+	if limit := req.totalLimit(); limit >= 0 && total > limit {
+		section["total"] = obj{"value": limit, "relation": "gte"}
+	}
+	if req.Highlight != nil {
+		for i, o := range section["hits"].([]obj) {
+			if hl := highlight(req.Highlight, req.Query, hits[i].doc.source); hl != nil {
+				o["highlight"] = hl
+			}
+		}
+	}
+
+	res := obj{
+		"took":      0,
+		"timed_out": false,
+		"_shards":   obj{"total": 1, "successful": 1, "skipped": 0, "failed": 0},
+		"hits":      section,
+	}
+	if len(req.Suggest) > 0 {
+		suggest, err := s.suggest(index, req.Suggest)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		}
+		res["suggest"] = suggest
+	}
+	if aggs != nil {
+		res["aggregations"] = aggs
+	}
+	if terminated {
+		res["terminated_early"] = true
+	}
+	return http.StatusOK, res
+}
+
+// msearch runs the searches of NDJSON header and body pairs, index is the default for headers without one
+func (s *Server) msearch(index string, body []byte) (int, interface{}) {
+	var lines [][]byte
+	for _, l := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(l)) > 0 {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines)%2 != 0 {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", "msearch request must have a body for each header")
+	}
+
+	responses := make([]interface{}, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		var head struct {
+			Index string `json:"index"`
+		}
+		if err := json.Unmarshal(lines[i], &head); err != nil {
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("Malformed header line [%d]", i+1))
+		}
+		if head.Index == "" {
+			head.Index = index
+		}
+		status, res := s.search(head.Index, url.Values{}, lines[i+1])
+		if m, ok := res.(obj); ok {
+			m["status"] = status
+		}
+		responses = append(responses, res)
+	}
+	return http.StatusOK, obj{"took": 0, "responses": responses}
+}
+
+// count returns the number of documents matching the query
+func (s *Server) count(index string, body []byte) (int, interface{}) {
+	var req searchRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errorBody(http.StatusBadRequest, "parsing_exception", err.Error())
+		}
+	}
+	hits, err := s.match(index, req.Query)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parsing_exception", err.Error())
+	}
+	return http.StatusOK, obj{
+		"count":   len(hits),
+		"_shards": obj{"total": 1, "successful": 1, "skipped": 0, "failed": 0},
+	}
+}
+
+// deleteByQuery deletes the matching documents
+func (s *Server) deleteByQuery(index string, body []byte) (int, interface{}) {
+	var req searchRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errorBody(http.StatusBadRequest, "parsing_exception", err.Error())
+		}
+	}
+	hits, err := s.match(index, req.Query)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parsing_exception", err.Error())
+	}
+	for _, h := range hits {
+		s.delete(h.index, h.id)
+	}
+	return http.StatusOK, obj{
+		"took":      0,
+		"timed_out": false,
+		"total":     len(hits),
+		"deleted":   len(hits),
+		"failures":  []interface{}{},
+	}
+}
+
+// hitsObj renders the hits section of a search response
+func hitsObj(hits []hit, total int, sort []sortField) obj {
+	out := make([]obj, 0, len(hits))
+	for _, h := range hits {
+		o := obj{
+			"_index":  h.index,
+			"_type":   "_doc",
+			"_id":     h.id,
+			"_score":  1.0,
+			"_source": h.doc.source,
+		}
+		if len(sort) > 0 {
+			values := make([]interface{}, len(sort))
+			for i, f := range sort {
+				values[i] = sortValue(h, f.field)
+			}
+			o["sort"] = values
+		}
+		out = append(out, o)
+	}
+	return obj{
+		"total":     obj{"value": total, "relation": "eq"},
+		"max_score": 1.0,
+		"hits":      out,
+	}
+}
+
+// match returns documents of the index matching the query in the order of indexing
+func (s *Server) match(index string, query json.RawMessage) ([]hit, error) {
+	var hits []hit
+	for _, name := range s.resolve(index) {
+		for id, doc := range s.indices[name] {
+			ok, err := matches(id, doc.source, query)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				hits = append(hits, hit{index: name, id: id, doc: doc})
+			}
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].doc.seqNo < hits[j].doc.seqNo })
+	return hits, nil
+}
+
+func matches(id string, src map[string]interface{}, raw json.RawMessage) (bool, error) {
+	if len(raw) == 0 {
+		return true, nil
+	}
+	var q map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &q); err != nil {
+		return false, err
+	}
+	for typ, body := range q {
+		ok, err := matchClause(typ, id, src, body)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchClause(typ, id string, src map[string]interface{}, body json.RawMessage) (bool, error) {
+	switch typ {
+	case "match_all":
+		return true, nil
+
+	case "match_none":
+		return false, nil
+
+	case "ids":
+		var q struct {
+			Values []string `json:"values"`
+		}
+		if err := json.Unmarshal(body, &q); err != nil {
+			return false, err
+		}
+		for _, v := range q.Values {
+			if v == id {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "term", "match", "match_phrase":
+		field, value, err := fieldValue(body, "value", "query")
+		if err != nil {
+			return false, err
+		}
+		return anyValue(getPath(src, field), func(v interface{}) bool {
+			if typ == "term" {
+				return equal(v, value)
+			}
+			return matchText(v, value)
+		}), nil
+
+	case "terms":
+		var q map[string][]interface{}
+		if err := json.Unmarshal(body, &q); err != nil {
+			return false, err
+		}
+		for field, values := range q {
+			return anyValue(getPath(src, field), func(v interface{}) bool {
+				for _, value := range values {
+					if equal(v, value) {
+						return true
+					}
+				}
+				return false
+			}), nil
+		}
+		return false, nil
+
+	case "prefix":
+		field, value, err := fieldValue(body, "value")
+		if err != nil {
+			return false, err
+		}
+		return anyValue(getPath(src, field), func(v interface{}) bool {
+			s, ok := v.(string)
+			p, _ := value.(string)
+			return ok && strings.HasPrefix(s, p)
+		}), nil
+
+	case "exists":
+		var q struct {
+			Field string `json:"field"`
+		}
+		if err := json.Unmarshal(body, &q); err != nil {
+			return false, err
+		}
+		return getPath(src, q.Field) != nil, nil
+
+	case "range":
+		var q map[string]map[string]interface{}
+		if err := json.Unmarshal(body, &q); err != nil {
+			return false, err
+		}
+		for field, bounds := range q {
+			return anyValue(getPath(src, field), func(v interface{}) bool {
+				for op, bound := range bounds {
+					c, ok := compare(v, bound)
+					if !ok {
+						continue
+					}
+					switch op {
+					case "gt":
+						if c <= 0 {
+							return false
+						}
+					case "gte":
+						if c < 0 {
+							return false
+						}
+					case "lt":
+						if c >= 0 {
+							return false
+						}
+					case "lte":
+						if c > 0 {
+							return false
+						}
+					}
+				}
+				return true
+			}), nil
+		}
+		return true, nil
+
+	case "bool":
+		return matchBool(id, src, body)
+	}
+
+	return false, fmt.Errorf("escrudtest does not support [%s] query", typ)
+}
+
+func matchBool(id string, src map[string]interface{}, body json.RawMessage) (bool, error) {
+	var q map[string]json.RawMessage
+	if err := json.Unmarshal(body, &q); err != nil {
+		return false, err
+	}
+
+	clauses := func(name string) ([]json.RawMessage, error) {
+		raw, ok := q[name]
+		if !ok {
+			return nil, nil
+		}
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err == nil {
+			return list, nil
+		}
+		return []json.RawMessage{raw}, nil
+	}
+
+	for _, name := range []string{"must", "filter"} {
+		list, err := clauses(name)
+		if err != nil {
+			return false, err
+		}
+		for _, c := range list {
+			if ok, err := matches(id, src, c); err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+
+	mustNot, err := clauses("must_not")
+	if err != nil {
+		return false, err
+	}
+	for _, c := range mustNot {
+		if ok, err := matches(id, src, c); err != nil || ok {
+			return false, err
+		}
+	}
+
+	should, err := clauses("should")
+	if err != nil {
+		return false, err
+	}
+	if len(should) == 0 {
+		return true, nil
+	}
+	_, hasMust := q["must"]
+	_, hasFilter := q["filter"]
+	if hasMust || hasFilter {
+		// should only affects scoring then
+		return true, nil
+	}
+	for _, c := range should {
+		if ok, err := matches(id, src, c); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// fieldValue parses {"field": value} or {"field": {"<key>": value}}
+func fieldValue(body json.RawMessage, keys ...string) (string, interface{}, error) {
+	var q map[string]interface{}
+	if err := json.Unmarshal(body, &q); err != nil {
+		return "", nil, err
+	}
+	for field, v := range q {
+		if m, ok := v.(map[string]interface{}); ok {
+			for _, k := range keys {
+				if val, ok := m[k]; ok {
+					return field, val, nil
+				}
+			}
+		}
+		return field, v, nil
+	}
+	return "", nil, fmt.Errorf("query has no field")
+}
+
+// anyValue applies fn to the value or, for arrays, to each of its items
+func anyValue(v interface{}, fn func(interface{}) bool) bool {
+	if arr, ok := v.([]interface{}); ok {
+		for _, item := range arr {
+			if fn(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return v != nil && fn(v)
+}
+
+// matchText is a rough analyzer: any of the query words is in the text, case insensitive
+func matchText(v, query interface{}) bool {
+	text, ok := v.(string)
+	if !ok {
+		return equal(v, query)
+	}
+	q := fmt.Sprint(query)
+	words := strings.Fields(strings.ToLower(text))
+	for _, w := range strings.Fields(strings.ToLower(q)) {
+		for _, t := range words {
+			if strings.Trim(t, ".,!?;:\"'") == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compare orders numbers and strings, ok is false for other types
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+type sortField struct {
+	field string
+	desc  bool
+}
+
+func parseSort(raw json.RawMessage) ([]sortField, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var list []interface{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var single interface{}
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, err
+		}
+		list = []interface{}{single}
+	}
+
+	var fields []sortField
+	for _, item := range list {
+		switch t := item.(type) {
+		case string:
+			fields = append(fields, sortField{field: t})
+		case map[string]interface{}:
+			for field, order := range t {
+				sf := sortField{field: field}
+				switch o := order.(type) {
+				case string:
+					sf.desc = o == "desc"
+				case map[string]interface{}:
+					sf.desc = o["order"] == "desc"
+				}
+				fields = append(fields, sf)
+			}
+		}
+	}
+	return fields, nil
+}
+
+func sortHits(hits []hit, fields []sortField) {
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		values := make([]interface{}, len(fields))
+		for k, f := range fields {
+			values[k] = sortValue(hits[j], f.field)
+		}
+		return compareSort(hits[i], fields, values) < 0
+	})
+}
+
+// compareSort compares the hit with the sort values in the sort order,
+// missing values go last
+func compareSort(h hit, fields []sortField, values []interface{}) int {
+	for i, f := range fields {
+		a, b := sortValue(h, f.field), values[i]
+		if a == nil || b == nil {
+			if a == nil && b == nil {
+				continue
+			}
+			if a == nil {
+				return 1
+			}
+			return -1
+		}
+		c, _ := compare(a, b)
+		if c == 0 {
+			continue
+		}
+		if f.desc {
+			return -c
+		}
+		return c
+	}
+	return 0
+}
+
+func sortValue(h hit, field string) interface{} {
+	if field == "_id" {
+		return h.id
+	}
+	return getPath(h.doc.source, field)
+}
@@ -0,0 +1,212 @@
+package escrudtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Mode of a Recorder
+type Mode int
+
+const (
+	// ModeAuto replays the cassette if its file exists and records it otherwise
+	ModeAuto Mode = iota
+	// ModeRecord passes requests to the real transport and saves them
+	ModeRecord
+	// ModeReplay answers from the cassette only, without network
+	ModeReplay
+)
+
+// Interaction is a recorded request with its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the part of the request used for matching
+type RecordedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse is enough of the response for the elastic client
+type RecordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Recorder is a http.RoundTripper which records elastic requests and responses
+// to a cassette file, or replays them from it. Pass it to escrud.WithTransport.
+//
+//	rec, err := escrudtest.NewRecorder("testdata/create.json", escrudtest.ModeAuto, &escrud.Transport{})
+//	defer rec.Stop()
+//	es, err := escrud.Connect(host, 9200, "http", escrud.WithTransport(rec))
+//
+// Requests are matched on method, path and body, with JSON bodies normalized.
+// Each recorded interaction is replayed once, in the recorded order.
+type Recorder struct {
+	path string
+	mode Mode
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewRecorder opens the cassette at path. next is the real transport used when recording,
+// http.DefaultTransport if nil.
+func NewRecorder(path string, mode Mode, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	r := &Recorder{path: path, mode: mode, next: next}
+
+	if mode == ModeAuto {
+		r.mode = ModeRecord
+		if _, err := os.Stat(path); err == nil {
+			r.mode = ModeReplay
+		}
+	}
+
+	if r.mode == ModeReplay {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read cassette: %v", err)
+		}
+		if err := json.Unmarshal(b, &r.interactions); err != nil {
+			return nil, fmt.Errorf("cassette contains bad json: %v", err)
+		}
+		r.used = make([]bool, len(r.interactions))
+	}
+	return r, nil
+}
+
+// Mode returns ModeRecord or ModeReplay, the one actually used
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// RoundTrip records or replays a single request
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read request body: %v", err)
+		}
+	}
+	recorded := RecordedRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.RawQuery,
+		Body:   normalizeBody(body),
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded)
+	}
+
+	if req.Body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response body: %v", err)
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{
+		Request:  recorded,
+		Response: RecordedResponse{Status: res.StatusCode, Header: res.Header, Body: string(resBody)},
+	})
+	r.mu.Unlock()
+	return res, nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.interactions {
+		if r.used[i] || !matchRequest(in.Request, recorded) {
+			continue
+		}
+		r.used[i] = true
+		header := in.Response.Header
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+			StatusCode:    in.Response.Status,
+			Header:        header.Clone(),
+			Body:          ioutil.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("escrudtest: no recorded interaction for %s %s in %s", recorded.Method, recorded.Path, r.path)
+}
+
+// Stop saves the cassette after recording. It does nothing when replaying.
+func (r *Recorder) Stop() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("cannot create cassette dir: %v", err)
+	}
+	return ioutil.WriteFile(r.path, b, 0o644)
+}
+
+func matchRequest(a, b RecordedRequest) bool {
+	return a.Method == b.Method && a.Path == b.Path && a.Body == b.Body
+}
+
+// normalizeBody re-encodes JSON and NDJSON bodies so formatting and key order don't matter
+func normalizeBody(body []byte) string {
+	var lines []string
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(line, &v); err != nil {
+			// not NDJSON, may be a pretty printed document
+			if err := json.Unmarshal(body, &v); err != nil {
+				return string(body)
+			}
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+		b, _ := json.Marshal(v)
+		lines = append(lines, string(b))
+	}
+	return strings.Join(lines, "\n")
+}
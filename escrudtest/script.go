@@ -0,0 +1,546 @@
+package escrudtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoop is returned by a ScriptFunc which leaves the document untouched,
+// as setting ctx.op = "none" does in painless
+var ErrNoop = errors.New("noop")
+
+// ScriptFunc emulates a painless update script. It changes src in place.
+// fields are the names captured by the placeholders of the script template,
+// in the order of fmt arguments which fill them.
+type ScriptFunc func(src map[string]interface{}, fields []string, params map[string]interface{}) error
+
+type script struct {
+	re   *regexp.Regexp
+	args []int // fmt argument index of each capture group
+	fn   ScriptFunc
+}
+
+// HandleScript registers an emulation of a painless script.
+// The template is the script source where %s or %[n]s stands for a field name or path,
+// each of them is captured separately. Whitespace in it matches any whitespace.
+func (s *Server) HandleScript(template string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, newScript(template, fn))
+}
+
+// placeholder matches %s and %[n]s in a quoted template
+var placeholder = regexp.MustCompile(`%(?:\\\[(\d+)\\\])?s`)
+
+func newScript(template string, fn ScriptFunc) script {
+	p := regexp.QuoteMeta(strings.TrimSpace(template))
+
+	// number the captures as fmt numbers its arguments
+	var args []int
+	next := 0
+	for _, m := range placeholder.FindAllStringSubmatch(p, -1) {
+		if m[1] != "" {
+			next, _ = strconv.Atoi(m[1])
+			next--
+		}
+		args = append(args, next)
+		next++
+	}
+
+	p = placeholder.ReplaceAllString(p, `([\w.]+)`)
+	p = regexp.MustCompile(`\s+`).ReplaceAllString(p, `\s*`)
+	return script{
+		re:   regexp.MustCompile(`^\s*` + p + `\s*$`),
+		args: args,
+		fn:   fn,
+	}
+}
+
+func (s *Server) runScript(source string, src, params map[string]interface{}) error {
+	for i := len(s.scripts) - 1; i >= 0; i-- {
+		m := s.scripts[i].re.FindStringSubmatch(source)
+		if m == nil {
+			continue
+		}
+		sc := s.scripts[i]
+		var fields []string
+		for g, arg := range sc.args {
+			for len(fields) <= arg {
+				fields = append(fields, "")
+			}
+			fields[arg] = m[g+1]
+		}
+		return sc.fn(src, fields, params)
+	}
+	return fmt.Errorf("escrudtest cannot emulate script [%s]", source)
+}
+
+func parseScript(raw json.RawMessage) (string, map[string]interface{}, error) {
+	var inline string
+	if err := json.Unmarshal(raw, &inline); err == nil {
+		return inline, map[string]interface{}{}, nil
+	}
+	var sc struct {
+		Source string                 `json:"source"`
+		Inline string                 `json:"inline"`
+		Params map[string]interface{} `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &sc); err != nil {
+		return "", nil, err
+	}
+	if sc.Source == "" {
+		sc.Source = sc.Inline
+	}
+	if sc.Params == nil {
+		sc.Params = map[string]interface{}{}
+	}
+	return sc.Source, sc.Params, nil
+}
+
+func scriptError(source string, err error) (int, obj) {
+	status, body := errorBody(http.StatusBadRequest, "illegal_argument_exception", "failed to execute script")
+	body["error"].(obj)["caused_by"] = obj{
+		"type":   "script_exception",
+		"reason": err.Error(),
+		"script": source,
+		"lang":   "painless",
+	}
+	return status, body
+}
+
+// fieldScript is the prelude of the escrud field scripts
+const fieldScript = `
+Map parent(Map o, List path, boolean create) { for (int i = 0; i < path.size() - 1; i++) { def next = o.get(path[i]); if (!(next instanceof Map)) { if (!create || next != null) { return null; } next = new HashMap(); o.put(path[i], next); } o = next; } return o; }
+String key(List path) { return path[path.size() - 1]; }
+`
+
+// builtinScripts emulate the scripts sent by escrud
+var builtinScripts = []script{
+	// IncrementField
+	newScript(`ctx._source.%s+=params.id`, func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+		v, ok := getPath(src, f[0]).(float64)
+		if !ok {
+			return fmt.Errorf("cannot increment [%s]: not a number", f[0])
+		}
+		incr, _ := p["id"].(float64)
+		return setPath(src, f[0], v+incr)
+	}),
+
+	// InsertArrayItem
+	newScript(`if (!ctx._source.containsKey("%s")) { ctx._source.%s = [params.new] } else { ctx._source.%s.add(params.new) }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, _ := getPath(src, f[0]).([]interface{})
+			return setPath(src, f[0], append(arr, p["new"]))
+		}),
+
+	// UpdateArrayItem
+	newScript(`for (def i = 0; i < ctx._source.%s.length; i++) {if (ctx._source.%s[i].%s == params.id) {ctx._source.%s[i] = params.replace;}}`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, err := array(src, f[0])
+			if err != nil {
+				return err
+			}
+			for i, item := range arr {
+				if m, ok := item.(map[string]interface{}); ok && equal(getPath(m, f[2]), p["id"]) {
+					arr[i] = p["replace"]
+				}
+			}
+			return nil
+		}),
+
+	// SortArrayItems
+	newScript(`ctx._source.%[1]s.sort((a, b) -> params.desc ? Double.compare(b.%[2]s, a.%[2]s) : Double.compare(a.%[2]s, b.%[2]s))`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, err := array(src, f[0])
+			if err != nil {
+				return err
+			}
+			desc, _ := p["desc"].(bool)
+			sort.SliceStable(arr, func(i, j int) bool {
+				a, _ := getPath(item(arr[i]), f[1]).(float64)
+				b, _ := getPath(item(arr[j]), f[1]).(float64)
+				if desc {
+					return a > b
+				}
+				return a < b
+			})
+			return nil
+		}),
+
+	// MoveArrayItem
+	newScript(`def a = ctx._source.%[1]s; int from = -1; for (int i = 0; i < a.size(); i++) { if (a[i].%[2]s == params.id) { from = i; break; } } if (from < 0) { ctx.op = 'none' } else { def item = a.remove(from); a.add((int) Math.max(0, Math.min(params.position, a.size())), item) }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, err := array(src, f[0])
+			if err != nil {
+				return err
+			}
+			for i, it := range arr {
+				if !equal(getPath(item(it), f[1]), p["id"]) {
+					continue
+				}
+				arr = append(arr[:i], arr[i+1:]...)
+				return setPath(src, f[0], insertAt(arr, p["position"], it))
+			}
+			return ErrNoop
+		}),
+
+	// InsertArrayItemAt
+	newScript(`if (!ctx._source.containsKey("%[1]s")) { ctx._source.%[1]s = [] } def a = ctx._source.%[1]s; a.add((int) Math.max(0, Math.min(params.position, a.size())), params.new)`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, _ := getPath(src, f[0]).([]interface{})
+			return setPath(src, f[0], insertAt(arr, p["position"], p["new"]))
+		}),
+
+	// InsertArrayItemIfAbsent
+	newScript(`if (!ctx._source.containsKey("%[1]s")) { ctx._source.%[1]s = [] } def a = ctx._source.%[1]s; boolean found = false; for (def li : a) { if (li.%[2]s == params.new.%[2]s) { found = true } } if (found) { ctx.op = 'none' } else { a.add(params.new) }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, _ := getPath(src, f[0]).([]interface{})
+			key := getPath(item(p["new"]), f[1])
+			for _, it := range arr {
+				if equal(getPath(item(it), f[1]), key) {
+					return ErrNoop
+				}
+			}
+			return setPath(src, f[0], append(arr, p["new"]))
+		}),
+
+	// InsertArrayItems
+	newScript(`if (!ctx._source.containsKey("%[1]s")) { ctx._source.%[1]s = [] } ctx._source.%[1]s.addAll(params.new)`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, _ := getPath(src, f[0]).([]interface{})
+			items, _ := p["new"].([]interface{})
+			return setPath(src, f[0], append(arr, items...))
+		}),
+
+	// CapArray
+	newScript(`def a = ctx._source.%[1]s; if (a == null || a.size() <= params.max) { ctx.op = 'none' } else { ctx._source.%[1]s = new ArrayList(a.subList(0, params.max)) }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, _ := getPath(src, f[0]).([]interface{})
+			max, _ := p["max"].(float64)
+			if len(arr) <= int(max) {
+				return ErrNoop
+			}
+			return setPath(src, f[0], arr[:int(max)])
+		}),
+
+	// PatchArrayItems
+	newScript(`
+def get(def o, def path) { for (def k : path) { if (!(o instanceof Map)) { return null; } o = o.get(k); } return o; }
+void merge(Map dst, Map patch) { for (def e : patch.entrySet()) { def v = e.getValue(); if (v == null) { dst.remove(e.getKey()); } else if (v instanceof Map && dst.get(e.getKey()) instanceof Map) { merge(dst.get(e.getKey()), v); } else { dst.put(e.getKey(), v); } } }
+def arr = get(ctx._source, params.path);
+int n = 0;
+if (arr instanceof List) { for (def li : arr) { boolean ok = li instanceof Map; for (def c : params.match) { if (ok && get(li, c.path) != c.value) { ok = false; } } if (ok) { merge(li, params.patch); n++; } } }
+if (n == 0) { ctx.op = 'none'; }
+`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, _ := getPath(src, joinPath(p["path"])).([]interface{})
+			conditions, _ := p["match"].([]interface{})
+			patch, _ := p["patch"].(map[string]interface{})
+			n := 0
+			for _, it := range arr {
+				m, ok := it.(map[string]interface{})
+				for _, c := range conditions {
+					c := item(c)
+					if ok && !equal(getPath(m, joinPath(c["path"])), c["value"]) {
+						ok = false
+					}
+				}
+				if ok {
+					mergePatch(m, patch)
+					n++
+				}
+			}
+			if n == 0 {
+				return ErrNoop
+			}
+			return nil
+		}),
+
+	// UpdateDiff removing fields
+	newScript(`
+void merge(Map dst, Map doc) { for (def e : doc.entrySet()) { def v = e.getValue(); if (v == null) { dst.remove(e.getKey()); } else if (v instanceof Map && dst.get(e.getKey()) instanceof Map) { merge(dst.get(e.getKey()), v); } else { dst.put(e.getKey(), v); } } }
+merge(ctx._source, params.doc);
+`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			doc, _ := p["doc"].(map[string]interface{})
+			mergePatch(src, doc)
+			return nil
+		}),
+
+	// Counter
+	newScript(`for (def e : params.fields.entrySet()) { def v = ctx._source[e.getKey()]; ctx._source[e.getKey()] = (v == null ? 0 : v) + e.getValue(); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			fields, _ := p["fields"].(map[string]interface{})
+			for k, incr := range fields {
+				v, _ := src[k].(float64)
+				n, _ := incr.(float64)
+				src[k] = v + n
+			}
+			return nil
+		}),
+
+	// DecrementField
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); if (v == null) { v = 0; } if (v <= params.floor) { ctx.op = 'none'; } else { def n = v - params.by; p.put(k, n < params.floor ? params.floor : n); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			path := joinPath(p["path"])
+			v, _ := getPath(src, path).(float64)
+			floor, _ := p["floor"].(float64)
+			by, _ := p["by"].(float64)
+			if v <= floor {
+				return ErrNoop
+			}
+			return setPath(src, path, math.Max(floor, v-by))
+		}),
+
+	// IncrementFloatField
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); p.put(k, (v == null ? 0 : v) + params.by);`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			path := joinPath(p["path"])
+			v, _ := getPath(src, path).(float64)
+			by, _ := p["by"].(float64)
+			return setPath(src, path, v+by)
+		}),
+
+	// SetFieldMax
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); if (v != null && v >= params.value) { ctx.op = 'none'; } else { p.put(k, params.value); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			path := joinPath(p["path"])
+			value, _ := p["value"].(float64)
+			if v, ok := getPath(src, path).(float64); ok && v >= value {
+				return ErrNoop
+			}
+			return setPath(src, path, value)
+		}),
+
+	// SetFieldMin
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); if (v != null && v <= params.value) { ctx.op = 'none'; } else { p.put(k, params.value); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			path := joinPath(p["path"])
+			value, _ := p["value"].(float64)
+			if v, ok := getPath(src, path).(float64); ok && v <= value {
+				return ErrNoop
+			}
+			return setPath(src, path, value)
+		}),
+
+	// SetFieldIfAbsent
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, true); String k = key(params.path); if (p.get(k) != null) { ctx.op = 'none'; } else { p.put(k, params.value); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			path := joinPath(p["path"])
+			if getPath(src, path) != nil {
+				return ErrNoop
+			}
+			return setPath(src, path, p["value"])
+		}),
+
+	// ToggleField
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, true); String k = key(params.path); p.put(k, p.get(k) != true);`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			path := joinPath(p["path"])
+			return setPath(src, path, getPath(src, path) != true)
+		}),
+
+	// RemoveField
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, false); if (p == null || !p.containsKey(key(params.path))) { ctx.op = 'none'; } else { p.remove(key(params.path)); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			if _, ok := removePath(src, joinPath(p["path"])); !ok {
+				return ErrNoop
+			}
+			return nil
+		}),
+
+	// RenameField
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, false); if (p == null || !p.containsKey(key(params.path))) { ctx.op = 'none'; } else { def v = p.remove(key(params.path)); parent(ctx._source, params.to, true).put(key(params.to), v); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			v, ok := removePath(src, joinPath(p["path"]))
+			if !ok {
+				return ErrNoop
+			}
+			return setPath(src, joinPath(p["to"]), v)
+		}),
+
+	// AppendToStringList
+	newScript(fieldScript+`Map p = parent(ctx._source, params.path, true); String k = key(params.path); def a = p.get(k); if (a == null) { a = new ArrayList(); p.put(k, a); } if (params.unique && a.contains(params.value)) { ctx.op = 'none'; } else { a.add(params.value); }`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			path := joinPath(p["path"])
+			var arr []interface{}
+			if getPath(src, path) != nil {
+				var err error
+				if arr, err = array(src, path); err != nil {
+					return err
+				}
+			}
+			if p["unique"] == true {
+				for _, v := range arr {
+					if v == p["value"] {
+						return ErrNoop
+					}
+				}
+			}
+			return setPath(src, path, append(arr, p["value"]))
+		}),
+
+	// RemoveArrayItem
+	newScript(`ctx._source.%s.removeIf(li -> li.%s == params.id)`,
+		func(src map[string]interface{}, f []string, p map[string]interface{}) error {
+			arr, err := array(src, f[0])
+			if err != nil {
+				return err
+			}
+			kept := arr[:0]
+			for _, item := range arr {
+				if m, ok := item.(map[string]interface{}); ok && equal(getPath(m, f[1]), p["id"]) {
+					continue
+				}
+				kept = append(kept, item)
+			}
+			return setPath(src, f[0], kept)
+		}),
+}
+
+// item returns the array item as an object, nil for scalars
+func item(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// insertAt inserts v at the position clamped to the array bounds
+func insertAt(arr []interface{}, position interface{}, v interface{}) []interface{} {
+	pos, _ := position.(float64)
+	i := int(pos)
+	if i < 0 {
+		i = 0
+	}
+	if i > len(arr) {
+		i = len(arr)
+	}
+	arr = append(arr, nil)
+	copy(arr[i+1:], arr[i:])
+	arr[i] = v
+	return arr
+}
+
+// joinPath turns a path param, a list of keys, into a dot separated path
+func joinPath(v interface{}) string {
+	keys, _ := v.([]interface{})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprint(k)
+	}
+	return strings.Join(parts, ".")
+}
+
+// mergePatch applies a JSON merge patch (RFC 7396) to dst
+func mergePatch(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		pm, ok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+		if ok && dok {
+			mergePatch(dm, pm)
+			continue
+		}
+		dst[k] = deepCopy(v)
+	}
+}
+
+// array returns the array at path, it is an error if there's none as in painless
+func array(src map[string]interface{}, path string) ([]interface{}, error) {
+	arr, ok := getPath(src, path).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot access [%s]: not an array", path)
+	}
+	return arr, nil
+}
+
+// getPath returns the value at the dot separated path, nil if there's none
+func getPath(src map[string]interface{}, path string) interface{} {
+	var cur interface{} = src
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[key]
+	}
+	return cur
+}
+
+// removePath removes the value at the dot separated path, ok is false if there's none
+func removePath(src map[string]interface{}, path string) (v interface{}, ok bool) {
+	keys := strings.Split(path, ".")
+	m, _ := getPath(src, strings.Join(keys[:len(keys)-1], ".")).(map[string]interface{})
+	if len(keys) == 1 {
+		m = src
+	}
+	last := keys[len(keys)-1]
+	if v, ok = m[last]; ok {
+		delete(m, last)
+	}
+	return v, ok
+}
+
+// setPath sets the value at the dot separated path, creating missing objects
+func setPath(src map[string]interface{}, path string, v interface{}) error {
+	keys := strings.Split(path, ".")
+	m := src
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			if m[key] != nil {
+				return fmt.Errorf("cannot access [%s] of [%s]: not an object", key, path)
+			}
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = v
+	return nil
+}
+
+// merge applies a partial document as the update API does: objects are merged recursively
+func merge(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		pm, ok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+		if ok && dok {
+			merge(dm, pm)
+			continue
+		}
+		dst[k] = deepCopy(v)
+	}
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = deepCopy(val)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, val := range t {
+			a[i] = deepCopy(val)
+		}
+		return a
+	}
+	return v
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
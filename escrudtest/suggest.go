@@ -0,0 +1,223 @@
+package escrudtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// suggest supports term, phrase and completion suggesters. Term and phrase suggestions
+// are the words of the field within 2 edits, completion matches the prefix of the field
+// or of its "input" values.
+func (s *Server) suggest(index string, raw json.RawMessage) (obj, error) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	var globalText string
+	if t, ok := req["text"]; ok {
+		delete(req, "text")
+		json.Unmarshal(t, &globalText)
+	}
+
+	docs, err := s.match(index, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := obj{}
+	for name, body := range req {
+		var def map[string]json.RawMessage
+		if err := json.Unmarshal(body, &def); err != nil {
+			return nil, err
+		}
+		text := globalText
+		if t, ok := def["text"]; ok {
+			json.Unmarshal(t, &text)
+		}
+		var prefix string
+		json.Unmarshal(def["prefix"], &prefix)
+
+		var p struct {
+			Field string `json:"field"`
+			Size  *int   `json:"size"`
+		}
+		switch {
+		case def["term"] != nil:
+			json.Unmarshal(def["term"], &p)
+			out[name] = termSuggest(text, p.Field, intOr(p.Size, 5), docs)
+		case def["phrase"] != nil:
+			json.Unmarshal(def["phrase"], &p)
+			out[name] = phraseSuggest(text, p.Field, intOr(p.Size, 5), docs)
+		case def["completion"] != nil:
+			json.Unmarshal(def["completion"], &p)
+			out[name] = completionSuggest(prefix, p.Field, intOr(p.Size, 5), docs)
+		default:
+			return nil, fmt.Errorf("escrudtest does not support suggester [%s]", name)
+		}
+	}
+	return out, nil
+}
+
+func intOr(v *int, def int) int {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+// words returns the lowercased words of the field in the documents with their frequencies
+func words(field string, docs []hit) map[string]int {
+	freq := map[string]int{}
+	for _, h := range docs {
+		for _, v := range values(aggDoc{src: h.doc.source}, field) {
+			text, ok := v.(string)
+			if !ok {
+				continue
+			}
+			for _, w := range strings.Fields(strings.ToLower(text)) {
+				freq[strings.Trim(w, ".,!?;:\"'")]++
+			}
+		}
+	}
+	return freq
+}
+
+type termOption struct {
+	text  string
+	score float64
+	freq  int
+}
+
+// corrections returns the known words within 2 edits of the word, best first
+func corrections(word string, freq map[string]int) []termOption {
+	var opts []termOption
+	for w, n := range freq {
+		if w == word {
+			continue
+		}
+		d := distance(word, w)
+		if d == 0 || d > 2 {
+			continue
+		}
+		opts = append(opts, termOption{text: w, score: 1 - float64(d)/float64(len([]rune(word))), freq: n})
+	}
+	sort.Slice(opts, func(i, j int) bool {
+		if opts[i].score != opts[j].score {
+			return opts[i].score > opts[j].score
+		}
+		if opts[i].freq != opts[j].freq {
+			return opts[i].freq > opts[j].freq
+		}
+		return opts[i].text < opts[j].text
+	})
+	return opts
+}
+
+func termSuggest(text, field string, size int, docs []hit) []obj {
+	freq := words(field, docs)
+	var entries []obj
+	offset := 0
+	for _, word := range strings.Fields(text) {
+		offset = strings.Index(text[offset:], word) + offset
+		options := []obj{}
+		// words known to the index aren't corrected, as suggest_mode missing does
+		if freq[strings.ToLower(word)] == 0 {
+			for i, o := range corrections(strings.ToLower(word), freq) {
+				if i == size {
+					break
+				}
+				options = append(options, obj{"text": o.text, "score": o.score, "freq": o.freq})
+			}
+		}
+		entries = append(entries, obj{"text": word, "offset": offset, "length": len(word), "options": options})
+		offset += len(word)
+	}
+	return entries
+}
+
+func phraseSuggest(text, field string, size int, docs []hit) []obj {
+	freq := words(field, docs)
+	var plain, highlighted []string
+	changed := false
+	score := 1.0
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if freq[word] == 0 {
+			if opts := corrections(word, freq); len(opts) > 0 {
+				plain = append(plain, opts[0].text)
+				highlighted = append(highlighted, "<em>"+opts[0].text+"</em>")
+				score *= opts[0].score
+				changed = true
+				continue
+			}
+		}
+		plain = append(plain, word)
+		highlighted = append(highlighted, word)
+	}
+
+	options := []obj{}
+	if changed && size > 0 {
+		options = append(options, obj{
+			"text":        strings.Join(plain, " "),
+			"highlighted": strings.Join(highlighted, " "),
+			"score":       score,
+		})
+	}
+	return []obj{{"text": text, "offset": 0, "length": len(text), "options": options}}
+}
+
+func completionSuggest(prefix, field string, size int, docs []hit) []obj {
+	options := []obj{}
+	for _, h := range docs {
+		v := getPath(h.doc.source, field)
+		if m, ok := v.(map[string]interface{}); ok {
+			v = m["input"]
+		}
+		inputs, ok := v.([]interface{})
+		if !ok {
+			inputs = []interface{}{v}
+		}
+		for _, in := range inputs {
+			s, ok := in.(string)
+			if !ok || !strings.HasPrefix(strings.ToLower(s), strings.ToLower(prefix)) {
+				continue
+			}
+			options = append(options, obj{
+				"text":    s,
+				"_index":  h.index,
+				"_type":   "_doc",
+				"_id":     h.id,
+				"_score":  1.0,
+				"_source": h.doc.source,
+			})
+			break
+		}
+		if len(options) == size {
+			break
+		}
+	}
+	return []obj{{"text": prefix, "offset": 0, "length": len(prefix), "options": options}}
+}
+
+// distance is the Levenshtein distance of the words
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
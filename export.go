@@ -0,0 +1,311 @@
+package escrud
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// exportLine is a line of an export file
+type exportLine struct {
+	ID     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// Progress describes the state of Export, Import and Copy. It's passed to OnProgress after each batch
+// and returned with the error of a failed run to resume it by ResumeExport or ResumeImport.
+type Progress struct {
+	// Docs is the number of documents exported, read from the file or copied so far
+	Docs int64
+	// Total is the number of documents to export or copy, zero for Import
+	Total int64
+	// Failed is the number of documents elastic refused to index or the transformation failed on
+	Failed int64
+	// Written and Dropped are the numbers of documents written to the target and dropped
+	// by the transformation, Copy only
+	Written int64
+	Dropped int64
+	// Cursor is where the export stopped, pass it to ResumeExport
+	Cursor string
+	// Offset is the number of bytes of the complete batches Export wrote, a failed write may leave
+	// a part of the next batch after them. Cut the output there before ResumeExport.
+	Offset int64
+}
+
+// TransferOption configures Export, Import and Copy
+type TransferOption func(*transfer)
+
+type transfer struct {
+	query      []byte
+	batchSize  int
+	onProgress func(Progress)
+	cursor     string
+	skip       int64
+	write      []WriteOption
+	tiebreak   string
+	workers    int
+	rate       float64
+	onError    func(id string, err error)
+}
+
+func newTransfer(opts []TransferOption) *transfer {
+	t := &transfer{batchSize: 1000, tiebreak: "_id", workers: 1}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.batchSize <= 0 {
+		t.batchSize = 1000
+	}
+	if t.workers <= 0 {
+		t.workers = 1
+	}
+	return t
+}
+
+// ExportQuery exports or copies only the documents matching the query, all of them by default
+func ExportQuery(query []byte) TransferOption {
+	return func(t *transfer) {
+		t.query = query
+	}
+}
+
+// BatchSize sets the number of documents fetched by a search or sent by a bulk request, 1000 by default
+func BatchSize(n int) TransferOption {
+	return func(t *transfer) {
+		t.batchSize = n
+	}
+}
+
+// Tiebreak sets the unique field to read the documents in order of instead of _id. Sorting by _id
+// is deprecated in elastic 7 and disabled in 8, use a keyword copy of the id there.
+func Tiebreak(field string) TransferOption {
+	return func(t *transfer) {
+		t.tiebreak = field
+	}
+}
+
+// OnProgress calls fn after each batch
+func OnProgress(fn func(Progress)) TransferOption {
+	return func(t *transfer) {
+		t.onProgress = fn
+	}
+}
+
+// ResumeExport continues a failed Export after Progress.Cursor, append to the file it was writing cut to Progress.Offset
+func ResumeExport(cursor string) TransferOption {
+	return func(t *transfer) {
+		t.cursor = cursor
+	}
+}
+
+// ResumeImport continues a failed Import skipping Progress.Docs documents of the file
+func ResumeImport(docs int64) TransferOption {
+	return func(t *transfer) {
+		t.skip = docs
+	}
+}
+
+// ImportWriteOptions sets the options of the bulk requests of Import and Copy
+func ImportWriteOptions(opts ...WriteOption) TransferOption {
+	return func(t *transfer) {
+		t.write = opts
+	}
+}
+
+func (t *transfer) progress(p Progress) {
+	if t.onProgress != nil {
+		t.onProgress(p)
+	}
+}
+
+// Export writes the documents of the index to w as gzipped NDJSON, a line {"_id": ..., "_source": ...}
+// per document. Documents are read in batches sorted by _id or the Tiebreak field, each batch is a complete gzip member
+// written by a single call, so the output of a failed Export is readable up to Progress.Cursor and may be continued
+// by ResumeExport after cutting the output to Progress.Offset. An export of no documents is an empty gzip member:
+//
+//	f, _ := os.Create("article.ndjson.gz")
+//	p, err := es.Export("article", f, escrud.ExportQuery(q))
+//	if err != nil {
+//		// later, with f opened for writing, drop the part of a batch a failed write left
+//		f.Truncate(p.Offset)
+//		f.Seek(p.Offset, io.SeekStart)
+//		p, err = es.Export("article", f, escrud.ResumeExport(p.Cursor))
+//	}
+//
+// Offset of the resumed Export counts from where it started writing.
+func (Es *Client) Export(index string, w io.Writer, opts ...TransferOption) (p Progress, err error) {
+	ctx, span := Es.startSpan("export", index, "")
+	defer func() { endSpan(span, err) }()
+
+	t := newTransfer(opts)
+	p.Cursor = t.cursor
+	// a batch is compressed to buf and written by a single call, so a failed write
+	// doesn't leave a truncated member after the last complete one
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	err = Es.scan(ctx, index, t, func(hits []Hit, total int64, cursor string) error {
+		p.Total = total
+		buf.Reset()
+		zw.Reset(&buf)
+		for _, hit := range hits {
+			line, err := json.Marshal(exportLine{ID: hit.ID, Source: hit.Source})
+			if err != nil {
+				return fmt.Errorf("cannot encode document %s: %v", hit.ID, err)
+			}
+			zw.Write(append(line, '\n'))
+		}
+		zw.Close()
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("cannot write export: %v", err)
+		}
+		p.Offset += int64(buf.Len())
+		p.Docs += int64(len(hits))
+		p.Cursor = cursor
+		t.progress(p)
+		return nil
+	})
+	if err == nil && p.Docs == 0 {
+		// an empty member keeps the output a valid gzip stream
+		buf.Reset()
+		zw.Reset(&buf)
+		zw.Close()
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return p, fmt.Errorf("cannot write export: %v", err)
+		}
+		p.Offset += int64(buf.Len())
+	}
+	return p, err
+}
+
+// scan reads the documents matching the query of t in batches sorted by the tiebreak field, starting after t.cursor,
+// and passes them to fn with the total and the cursor after the batch until the end or an error
+func (Es *Client) scan(ctx context.Context, index string, t *transfer, fn func(hits []Hit, total int64, cursor string) error) error {
+	sort := []SortField{Asc(t.tiebreak)}
+	cursor := t.cursor
+	for {
+		searchOpts := []SearchOption{Size(t.batchSize), Sort(sort...), ExactTotal()}
+		if cursor != "" {
+			after, err := decodeCursor(cursor, len(sort))
+			if err != nil {
+				return err
+			}
+			searchOpts = append(searchOpts, SearchAfter(after...))
+		}
+		body, err := newSearchBody(Es.notDeleted(t.query), searchOpts)
+		if err != nil {
+			return err
+		}
+		sr, err := Es.search(ctx, index, body)
+		if err != nil {
+			return err
+		}
+		if len(sr.Hits) == 0 {
+			return nil
+		}
+
+		if cursor, err = encodeCursor(sr.Hits[len(sr.Hits)-1].Sort); err != nil {
+			return err
+		}
+		if err := fn(sr.Hits, sr.Total, cursor); err != nil {
+			return err
+		}
+		if len(sr.Hits) < t.batchSize {
+			return nil
+		}
+	}
+}
+
+// Import indexes the documents of an Export file into the index by bulk requests, overwriting
+// those with the same ids. Documents elastic refuses are counted in Progress.Failed and reported
+// by the error after the whole file is read. If a bulk request fails Import stops,
+// resume it by ResumeImport(p.Docs) with the file opened again.
+func (Es *Client) Import(index string, r io.Reader, opts ...TransferOption) (p Progress, err error) {
+	ctx, span := Es.startSpan("import", index, "")
+	defer func() { endSpan(span, err) }()
+
+	t := newTransfer(opts)
+	zr, err := gzip.NewReader(r)
+	if err == io.EOF {
+		// an empty file, as older Exports wrote for no documents
+		return p, nil
+	}
+	if err != nil {
+		return p, fmt.Errorf("cannot read import: %v", err)
+	}
+	defer zr.Close()
+
+	var (
+		batch    bytes.Buffer
+		n        int
+		firstErr error
+	)
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		res, err := Es.bulk(ctx, batch.Bytes(), Es.write(t.write))
+		if err != nil {
+			return err
+		}
+		for _, item := range res.Items {
+			if r := item.result(); r.Error != nil {
+				p.Failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("cannot import %s/%s: %v", r.Index, r.ID, r.Error)
+				}
+			}
+		}
+		p.Docs += int64(n)
+		batch.Reset()
+		n = 0
+		t.progress(p)
+		return nil
+	}
+
+	br := bufio.NewReader(zr)
+	var skipped int64
+	for {
+		line, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return p, fmt.Errorf("cannot read import: %v", readErr)
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			if skipped < t.skip {
+				skipped++
+				p.Docs++
+			} else {
+				var doc exportLine
+				if err := json.Unmarshal(line, &doc); err != nil {
+					return p, fmt.Errorf("bad line %d of import: %v", p.Docs+int64(n)+1, err)
+				}
+				action, _ := json.Marshal(map[string]interface{}{
+					"index": map[string]interface{}{"_index": index, "_id": doc.ID},
+				})
+				batch.Write(action)
+				batch.WriteByte('\n')
+				batch.Write(doc.Source)
+				batch.WriteByte('\n')
+				n++
+				if n >= t.batchSize {
+					if err := flush(); err != nil {
+						return p, err
+					}
+				}
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return p, err
+	}
+	if firstErr != nil {
+		return p, fmt.Errorf("%d documents failed, first: %v", p.Failed, firstErr)
+	}
+	return p, nil
+}
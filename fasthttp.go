@@ -1,39 +1,107 @@
 package escrud
 
 import (
-	"github.com/valyala/fasthttp"
+	"context"
 	"io/ioutil"
 	"net/http"
 	"strings"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Transport implements the estransport interface with
 // the github.com/valyala/fasthttp HTTP client.
 //
-type Transport struct{}
+type Transport struct {
+	// TracerProvider used for the HTTP spans, the global one if nil
+	TracerProvider trace.TracerProvider
+}
 
 // RoundTrip performs the request and returns a response or error
 //
-func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	freq := fasthttp.AcquireRequest()
-	defer fasthttp.ReleaseRequest(freq)
+func (t *Transport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	_, span := tracer(t.TracerProvider).Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrDBSystem.String("elasticsearch"),
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String()),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(attrStatusCode.Int(res.StatusCode))
+		}
+		span.End()
+	}()
 
+	freq := fasthttp.AcquireRequest()
 	fres := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(fres)
+	release := func() {
+		fasthttp.ReleaseRequest(freq)
+		fasthttp.ReleaseResponse(fres)
+	}
 
 	t.copyRequest(freq, req)
 
-	err := fasthttp.Do(freq, fres)
+	err = do(req.Context(), freq, fres, release)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	res := &http.Response{Header: make(http.Header)}
+	res = &http.Response{Header: make(http.Header)}
 	t.copyResponse(res, fres)
 
 	return res, nil
 }
 
+// do performs the request until it's done or ctx is, the request is cut at the deadline of ctx.
+// On an error it releases freq and fres, those of a request abandoned on cancellation
+// are released when it ends.
+//
+func do(ctx context.Context, freq *fasthttp.Request, fres *fasthttp.Response, release func()) error {
+	if ctx.Done() == nil {
+		err := fasthttp.Do(freq, fres)
+		if err != nil {
+			release()
+		}
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		release()
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if deadline, ok := ctx.Deadline(); ok {
+			done <- fasthttp.DoDeadline(freq, fres, deadline)
+		} else {
+			done <- fasthttp.Do(freq, fres)
+		}
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			release()
+		}
+		return err
+	case <-ctx.Done():
+		go func() {
+			<-done
+			release()
+		}()
+		return ctx.Err()
+	}
+}
+
 // copyRequest converts a http.Request to fasthttp.Request
 //
 func (t *Transport) copyRequest(dst *fasthttp.Request, src *http.Request) *fasthttp.Request {
@@ -0,0 +1,111 @@
+package escrud
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// fieldScript is the prelude of the field scripts, the field comes as params.path split by dots.
+// parent returns the object holding the field, creating missing objects if asked, null otherwise.
+const fieldScript = `
+Map parent(Map o, List path, boolean create) { for (int i = 0; i < path.size() - 1; i++) { def next = o.get(path[i]); if (!(next instanceof Map)) { if (!create || next != null) { return null; } next = new HashMap(); o.put(path[i], next); } o = next; } return o; }
+String key(List path) { return path[path.size() - 1]; }
+`
+
+const (
+	decrementFieldScript = fieldScript + `Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); if (v == null) { v = 0; } if (v <= params.floor) { ctx.op = 'none'; } else { def n = v - params.by; p.put(k, n < params.floor ? params.floor : n); }`
+
+	incrementFloatFieldScript = fieldScript + `Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); p.put(k, (v == null ? 0 : v) + params.by);`
+
+	setFieldMaxScript = fieldScript + `Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); if (v != null && v >= params.value) { ctx.op = 'none'; } else { p.put(k, params.value); }`
+
+	setFieldMinScript = fieldScript + `Map p = parent(ctx._source, params.path, true); String k = key(params.path); def v = p.get(k); if (v != null && v <= params.value) { ctx.op = 'none'; } else { p.put(k, params.value); }`
+
+	setFieldIfAbsentScript = fieldScript + `Map p = parent(ctx._source, params.path, true); String k = key(params.path); if (p.get(k) != null) { ctx.op = 'none'; } else { p.put(k, params.value); }`
+
+	toggleFieldScript = fieldScript + `Map p = parent(ctx._source, params.path, true); String k = key(params.path); p.put(k, p.get(k) != true);`
+
+	removeFieldScript = fieldScript + `Map p = parent(ctx._source, params.path, false); if (p == null || !p.containsKey(key(params.path))) { ctx.op = 'none'; } else { p.remove(key(params.path)); }`
+
+	renameFieldScript = fieldScript + `Map p = parent(ctx._source, params.path, false); if (p == null || !p.containsKey(key(params.path))) { ctx.op = 'none'; } else { def v = p.remove(key(params.path)); parent(ctx._source, params.to, true).put(key(params.to), v); }`
+
+	appendToStringListScript = fieldScript + `Map p = parent(ctx._source, params.path, true); String k = key(params.path); def a = p.get(k); if (a == null) { a = new ArrayList(); p.put(k, a); } if (params.unique && a.contains(params.value)) { ctx.op = 'none'; } else { a.add(params.value); }`
+)
+
+// Имена полей во всех операциях ниже могут быть вложенными путями через точку ("stats.viewed"),
+// они передаются в params, а не подставляются в текст скрипта.
+// Если операция ничего не меняет, документ не меняется (Result "noop").
+
+// DecrementField уменьшить числовое поле на decr, но не ниже floor. Отсутствующее поле считается нулем.
+// Если значение уже не больше floor, документ не меняется.
+func (Es *Client) DecrementField(index string, docID string, fieldName string, decr int, floor int, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("decrement", index, docID, decrementFieldScript, map[string]interface{}{
+		"path":  strings.Split(fieldName, "."),
+		"by":    decr,
+		"floor": floor,
+	}, opts)
+}
+
+// IncrementFloatField увеличить поле на дробное incr, например рейтинг. Отсутствующее поле считается нулем.
+func (Es *Client) IncrementFloatField(index string, docID string, fieldName string, incr float64, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("increment_float", index, docID, incrementFloatFieldScript, map[string]interface{}{
+		"path": strings.Split(fieldName, "."),
+		"by":   incr,
+	}, opts)
+}
+
+// SetFieldMax записать value в поле, если оно больше текущего значения или поля нет.
+func (Es *Client) SetFieldMax(index string, docID string, fieldName string, value float64, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("set_field_max", index, docID, setFieldMaxScript, map[string]interface{}{
+		"path":  strings.Split(fieldName, "."),
+		"value": value,
+	}, opts)
+}
+
+// SetFieldMin записать value в поле, если оно меньше текущего значения или поля нет.
+func (Es *Client) SetFieldMin(index string, docID string, fieldName string, value float64, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("set_field_min", index, docID, setFieldMinScript, map[string]interface{}{
+		"path":  strings.Split(fieldName, "."),
+		"value": value,
+	}, opts)
+}
+
+// SetFieldIfAbsent записать JSON value в поле, если поля нет или оно null.
+func (Es *Client) SetFieldIfAbsent(index string, docID string, fieldName string, value []byte, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("set_field_if_absent", index, docID, setFieldIfAbsentScript, map[string]interface{}{
+		"path":  strings.Split(fieldName, "."),
+		"value": json.RawMessage(value),
+	}, opts)
+}
+
+// ToggleField инвертировать булево поле. Отсутствующее поле считается false.
+func (Es *Client) ToggleField(index string, docID string, fieldName string, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("toggle_field", index, docID, toggleFieldScript, map[string]interface{}{
+		"path": strings.Split(fieldName, "."),
+	}, opts)
+}
+
+// RemoveField удалить поле из документа.
+func (Es *Client) RemoveField(index string, docID string, fieldName string, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("remove_field", index, docID, removeFieldScript, map[string]interface{}{
+		"path": strings.Split(fieldName, "."),
+	}, opts)
+}
+
+// RenameField перенести значение поля fieldName в поле newName. Значение newName, если оно было, заменяется.
+func (Es *Client) RenameField(index string, docID string, fieldName string, newName string, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("rename_field", index, docID, renameFieldScript, map[string]interface{}{
+		"path": strings.Split(fieldName, "."),
+		"to":   strings.Split(newName, "."),
+	}, opts)
+}
+
+// AppendToStringList добавить строку в конец списка, например тег. Списка может не быть - тогда добавить и список.
+// С unique строка, которая уже есть в списке, не добавляется.
+func (Es *Client) AppendToStringList(index string, docID string, fieldName string, value string, unique bool, opts ...WriteOption) (*ResponseBody, error) {
+	return Es.script("append_to_string_list", index, docID, appendToStringListScript, map[string]interface{}{
+		"path":   strings.Split(fieldName, "."),
+		"value":  value,
+		"unique": unique,
+	}, opts)
+}
@@ -0,0 +1,51 @@
+package escrud
+
+import "encoding/json"
+
+// Highlighter configures highlighting of the found words in the hits,
+// the snippets come in Hit.Highlight by field
+type Highlighter struct {
+	Fields []string
+	// FragmentSize is the size of a snippet in chars, 100 by default
+	FragmentSize int
+	// NumberOfFragments is the maximum number of snippets per field, 5 by default.
+	// Negative means the whole field highlighted as a single snippet.
+	NumberOfFragments int
+	// PreTags and PostTags wrap the found words, <em> and </em> by default
+	PreTags  []string
+	PostTags []string
+}
+
+// MarshalJSON encodes the highlighter as the highlight section of a search
+func (h Highlighter) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]struct{}, len(h.Fields))
+	for _, f := range h.Fields {
+		fields[f] = struct{}{}
+	}
+	body := map[string]interface{}{"fields": fields}
+	if h.FragmentSize > 0 {
+		body["fragment_size"] = h.FragmentSize
+	}
+	switch {
+	case h.NumberOfFragments > 0:
+		body["number_of_fragments"] = h.NumberOfFragments
+	case h.NumberOfFragments < 0:
+		body["number_of_fragments"] = 0
+	}
+	if len(h.PreTags) > 0 {
+		body["pre_tags"] = h.PreTags
+	}
+	if len(h.PostTags) > 0 {
+		body["post_tags"] = h.PostTags
+	}
+	return json.Marshal(body)
+}
+
+// Highlight adds highlighted snippets of the fields to the hits
+//
+//	es.Search("article", query, Highlight(Highlighter{Fields: []string{"title", "text"}, PreTags: []string{"<b>"}, PostTags: []string{"</b>"}}))
+func Highlight(h Highlighter) SearchOption {
+	return func(b *searchBody) {
+		b.Highlight = &h
+	}
+}
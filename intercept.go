@@ -0,0 +1,111 @@
+package escrud
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Call is a request of a Client operation passing through the interceptors
+type Call struct {
+	// Operation, Index and ID are those of the operation as in its span, e.g. "update", "article", "123".
+	// Operation is empty for requests made outside operations, like the info request of Connect.
+	// Several requests of an operation, like the source read before an audited update, share them.
+	// The write of an audit record by IndexAuditSink is the "audit" operation of its index.
+	Operation string
+	Index     string
+	ID        string
+	// Request is the HTTP request to elastic, change its URL or headers to change what is sent
+	Request *http.Request
+	// Body is the request body, change it to send another one
+	Body []byte
+}
+
+// Handler sends the call to the next interceptor or to elastic
+type Handler func(call *Call) (*http.Response, error)
+
+// Interceptor wraps the requests of the Client operations. It may change the call, pass it
+// to next any number of times or not at all, and change the response or the error.
+type Interceptor interface {
+	Intercept(call *Call, next Handler) (*http.Response, error)
+}
+
+// InterceptorFunc adapts a function to Interceptor
+type InterceptorFunc func(call *Call, next Handler) (*http.Response, error)
+
+// Intercept calls f
+func (f InterceptorFunc) Intercept(call *Call, next Handler) (*http.Response, error) {
+	return f(call, next)
+}
+
+// WithInterceptors adds the interceptors to the chain of the Client, the first one is the outermost:
+//
+//	es, err := escrud.Connect(host, port, scheme, escrud.WithInterceptors(
+//		escrud.InterceptorFunc(func(call *escrud.Call, next escrud.Handler) (*http.Response, error) {
+//			call.Request.Header.Set("Authorization", "ApiKey "+key)
+//			return next(call)
+//		}),
+//		metrics,
+//	))
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(c *Client) {
+		c.interceptors = append(c.interceptors, interceptors...)
+	}
+}
+
+type callKey struct{}
+
+type callInfo struct {
+	operation string
+	index     string
+	id        string
+}
+
+// withCall stores the operation in ctx for the interceptors
+func withCall(ctx context.Context, operation, index, id string) context.Context {
+	return context.WithValue(ctx, callKey{}, callInfo{operation, index, id})
+}
+
+// interceptTransport runs the requests through the interceptors
+type interceptTransport struct {
+	next  http.RoundTripper
+	chain []Interceptor
+}
+
+func (t *interceptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	call := &Call{Request: req.Clone(req.Context())}
+	if info, ok := req.Context().Value(callKey{}).(callInfo); ok {
+		call.Operation, call.Index, call.ID = info.operation, info.index, info.id
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read request body: %v", err)
+		}
+		call.Body = body
+	}
+	return t.handler(0)(call)
+}
+
+// handler returns the Handler calling the i-th interceptor
+func (t *interceptTransport) handler(i int) Handler {
+	if i == len(t.chain) {
+		return t.send
+	}
+	return func(call *Call) (*http.Response, error) {
+		return t.chain[i].Intercept(call, t.handler(i+1))
+	}
+}
+
+// send sends the call to elastic, the body is set anew so the call may be sent again
+func (t *interceptTransport) send(call *Call) (*http.Response, error) {
+	req := call.Request
+	if call.Body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(call.Body))
+		req.ContentLength = int64(len(call.Body))
+	}
+	return t.next.RoundTrip(req)
+}
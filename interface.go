@@ -0,0 +1,60 @@
+package escrud
+
+import "context"
+
+//go:generate mockgen -destination=escrudmock/escrudmock.go -package=escrudmock . Interface
+
+// Interface is the set of operations of Client. Depend on it instead of *Client
+// to inject escrudmock.MockInterface or a wrapper in tests.
+type Interface interface {
+	// WithContext returns a copy bound to ctx, see Client.WithContext
+	WithContext(ctx context.Context) Interface
+
+	// CRUD
+	Create(index string, id string, data []byte, opts ...WriteOption) error
+	CreateAuto(index string, data []byte, opts ...WriteOption) (*ResponseBody, error)
+	Index(index string, id string, data []byte, opts ...WriteOption) (*ResponseBody, error)
+	Read(index, id string) (*ResponseBody, error)
+	Source(index, id string) ([]byte, error)
+	Exists(index string, id string) (bool, error)
+	Update(index, id string, data []byte, opts ...WriteOption) (*ResponseBody, error)
+	UpdateDiff(index, id string, v interface{}, opts ...WriteOption) (*ResponseBody, []Change, error)
+	Delete(index, id string, opts ...WriteOption) (*ResponseBody, error)
+	Restore(index, id string, opts ...WriteOption) (*ResponseBody, error)
+
+	// Search
+	Search(index string, query []byte, opts ...SearchOption) (*SearchResult, error)
+	Count(index string, query []byte) (int64, error)
+	ExistsByQuery(index string, query []byte) (bool, error)
+	MultiSearch(requests []SearchRequest) ([]MultiSearchResult, error)
+
+	// Bulk
+	BulkCreate(datum []byte, opts ...WriteOption) error
+	Bulk(datum []byte, opts ...WriteOption) ([]BulkItemResult, error)
+
+	// Scripted updates
+	IncrementField(index string, docID string, fieldName string, incr int, opts ...WriteOption) (*ResponseBody, error)
+	InsertArrayItem(index string, docID string, arrayName string, elem []byte, opts ...WriteOption) (*ResponseBody, error)
+	UpdateArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, subst []byte, opts ...WriteOption) (*ResponseBody, error)
+	RemoveArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, opts ...WriteOption) (*ResponseBody, error)
+	SortArrayItems(index string, docID string, arrayName string, itemName string, desc bool, opts ...WriteOption) (*ResponseBody, error)
+	MoveArrayItem(index string, docID string, arrayName string, itemName string, itemValue int, position int, opts ...WriteOption) (*ResponseBody, error)
+	InsertArrayItemAt(index string, docID string, arrayName string, position int, elem []byte, opts ...WriteOption) (*ResponseBody, error)
+	InsertArrayItemIfAbsent(index string, docID string, arrayName string, itemName string, elem []byte, opts ...WriteOption) (*ResponseBody, error)
+	InsertArrayItems(index string, docID string, arrayName string, elems [][]byte, opts ...WriteOption) (*ResponseBody, error)
+	CapArray(index string, docID string, arrayName string, max int, opts ...WriteOption) (*ResponseBody, error)
+	PatchArrayItems(index string, docID string, arrayName string, match map[string]interface{}, patch []byte, opts ...WriteOption) (*ResponseBody, error)
+
+	// Field operations
+	DecrementField(index string, docID string, fieldName string, decr int, floor int, opts ...WriteOption) (*ResponseBody, error)
+	IncrementFloatField(index string, docID string, fieldName string, incr float64, opts ...WriteOption) (*ResponseBody, error)
+	SetFieldMax(index string, docID string, fieldName string, value float64, opts ...WriteOption) (*ResponseBody, error)
+	SetFieldMin(index string, docID string, fieldName string, value float64, opts ...WriteOption) (*ResponseBody, error)
+	SetFieldIfAbsent(index string, docID string, fieldName string, value []byte, opts ...WriteOption) (*ResponseBody, error)
+	ToggleField(index string, docID string, fieldName string, opts ...WriteOption) (*ResponseBody, error)
+	RemoveField(index string, docID string, fieldName string, opts ...WriteOption) (*ResponseBody, error)
+	RenameField(index string, docID string, fieldName string, newName string, opts ...WriteOption) (*ResponseBody, error)
+	AppendToStringList(index string, docID string, fieldName string, value string, unique bool, opts ...WriteOption) (*ResponseBody, error)
+}
+
+var _ Interface = (*Client)(nil)
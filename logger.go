@@ -0,0 +1,169 @@
+package escrud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Logger is used by escrud for its own messages and request/response debug logging.
+// Args are alternating key/value pairs, as in log/slog.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// WithLogger sets the logger of the Client. The global log package is used by default,
+// without debug messages.
+func WithLogger(l Logger) Option {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithBodyDump makes the debug request/response logging dump bodies as well.
+// Credentials are redacted in dumps.
+func WithBodyDump() Option {
+	return func(c *Client) {
+		c.dumpBodies = true
+	}
+}
+
+// NewSlogLogger adapts l to the Logger interface
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Debug(msg string, args ...interface{}) { s.l.Debug(msg, args...) }
+func (s slogLogger) Info(msg string, args ...interface{})  { s.l.Info(msg, args...) }
+func (s slogLogger) Error(msg string, args ...interface{}) { s.l.Error(msg, args...) }
+
+// stdLogger writes to the global log package as escrud always did
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, args ...interface{}) {}
+func (stdLogger) Info(msg string, args ...interface{})  { log.Println(formatArgs(msg, args)) }
+func (stdLogger) Error(msg string, args ...interface{}) { log.Println(formatArgs(msg, args)) }
+
+func formatArgs(msg string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	return b.String()
+}
+
+func (Es *Client) log() Logger {
+	if Es.logger == nil {
+		return stdLogger{}
+	}
+	return Es.logger
+}
+
+// roundTripLogger implements estransport.Logger on top of a Logger
+type roundTripLogger struct {
+	logger     Logger
+	dumpBodies bool
+}
+
+// LogRoundTrip writes the request and response with its timing at debug level
+func (l *roundTripLogger) LogRoundTrip(req *http.Request, res *http.Response, err error, start time.Time, dur time.Duration) error {
+	args := []interface{}{"start", start, "duration", dur}
+	if req != nil {
+		args = append(args, "method", req.Method, "url", redactURL(req))
+		if l.dumpBodies && req.Body != nil && req.Body != http.NoBody {
+			b, _ := ioutil.ReadAll(req.Body)
+			args = append(args, "request_body", redactBody(b))
+		}
+	}
+	if res != nil && res.StatusCode != 0 {
+		args = append(args, "status", res.StatusCode)
+		if l.dumpBodies && res.Body != nil && res.Body != http.NoBody {
+			b, _ := ioutil.ReadAll(res.Body)
+			args = append(args, "response_body", redactBody(b))
+		}
+	}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	l.logger.Debug("elasticsearch round trip", args...)
+	return nil
+}
+
+// RequestBodyEnabled makes the elastic client pass a copy of request body to the logger
+func (l *roundTripLogger) RequestBodyEnabled() bool { return l.dumpBodies }
+
+// ResponseBodyEnabled makes the elastic client pass a copy of response body to the logger
+func (l *roundTripLogger) ResponseBodyEnabled() bool { return l.dumpBodies }
+
+const redacted = "REDACTED"
+
+// redactURL returns the request URL without user info
+func redactURL(req *http.Request) string {
+	if req.URL == nil {
+		return ""
+	}
+	u := *req.URL
+	if u.User != nil {
+		u.User = nil
+		return strings.Replace(u.String(), "://", "://"+redacted+"@", 1)
+	}
+	return u.String()
+}
+
+var secretKey = regexp.MustCompile(`(?i)password|passwd|secret|token|api_?key|authorization|credentials`)
+
+// redactBody hides values of credential-like keys in JSON or NDJSON bodies
+func redactBody(b []byte) string {
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		var v interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			continue
+		}
+		if !redactValue(v) {
+			continue
+		}
+		if r, err := json.Marshal(v); err == nil {
+			lines[i] = string(r)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// redactValue replaces credentials in v in place and tells if anything was replaced
+func redactValue(v interface{}) bool {
+	changed := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if secretKey.MatchString(k) {
+				t[k] = redacted
+				changed = true
+				continue
+			}
+			changed = redactValue(val) || changed
+		}
+	case []interface{}:
+		for _, val := range t {
+			changed = redactValue(val) || changed
+		}
+	}
+	return changed
+}
@@ -0,0 +1,101 @@
+package escrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// SearchRequest is one of the searches of MultiSearch, with the arguments of Search
+type SearchRequest struct {
+	Index   string
+	Query   []byte
+	Options []SearchOption
+}
+
+// MultiSearchResult is the result of a search of MultiSearch, Err is set if the search failed
+type MultiSearchResult struct {
+	*SearchResult
+	Err error
+}
+
+// MultiSearch выполнить несколько независимых поисков одним запросом.
+// Результаты идут в порядке запросов, ошибка одного поиска не мешает остальным и попадает в его Err.
+// аналог запроса
+// POST http://localhost:9200/_msearch
+// {"index": "article"}
+// {"query": {{query}}}
+func (Es *Client) MultiSearch(requests []SearchRequest) (results []MultiSearchResult, err error) {
+	ctx, span := Es.startSpan("msearch", "", "")
+	defer func() { endSpan(span, err) }()
+
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	var body []byte
+	for i, r := range requests {
+		head, err := json.Marshal(map[string]string{"index": r.Index})
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode header of search %d: %v", i, err)
+		}
+		search, err := newSearchBody(Es.notDeleted(r.Query), r.Options)
+		if err != nil {
+			return nil, fmt.Errorf("search %d: %v", i, err)
+		}
+		body = append(body, head...)
+		body = append(body, '\n')
+		body = append(body, search...)
+		body = append(body, '\n')
+	}
+
+	es := Es.Client
+	res, err := es.Msearch(
+		bytes.NewReader(body),
+		es.Msearch.WithContext(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search: %v", err)
+	}
+	defer res.Body.Close()
+	spanStatus(ctx, res)
+
+	resp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response body: %v", err)
+	}
+
+	if res.IsError() {
+		return nil, fmt.Errorf("msearch failed. Status: %s, err: %s", res.Status(), resp)
+	}
+
+	var mr struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	if err := json.Unmarshal(resp, &mr); err != nil {
+		return nil, fmt.Errorf("response contains bad json: %v", err)
+	}
+	if len(mr.Responses) != len(requests) {
+		return nil, fmt.Errorf("msearch returned %d responses to %d searches", len(mr.Responses), len(requests))
+	}
+
+	results = make([]MultiSearchResult, len(requests))
+	for i, raw := range mr.Responses {
+		var item struct {
+			searchResponse
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &item); err != nil {
+			results[i].Err = fmt.Errorf("response to search %d contains bad json: %v", i, err)
+			continue
+		}
+		if item.Error != nil {
+			results[i].Err = fmt.Errorf("search %d failed. Status: %d, err: %s", i, item.Status, item.Error)
+			continue
+		}
+		results[i].SearchResult = item.result()
+	}
+	return results, nil
+}
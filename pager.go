@@ -0,0 +1,186 @@
+package escrud
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// defaultMaxResultWindow is the default index.max_result_window of elastic
+const defaultMaxResultWindow = 10000
+
+// Pager pages through the hits of a query in a stable order. Next pages are fetched
+// by opaque cursor tokens (search_after) which work at any depth, or by page numbers
+// (from/size) for the first max_result_window hits:
+//
+//	pager := es.NewPager("article", query, 20, Desc("date"))
+//	page, err := pager.Page(r.URL.Query().Get("cursor"))
+//	// respond with page.Hits and page.Next
+//
+// The sort is completed with _id, so documents with equal sort values keep their order.
+// Sorting by _id is deprecated in elastic 7 and disabled in 8, set a unique field of the documents by Tiebreak there.
+type Pager struct {
+	es              *Client
+	index           string
+	query           []byte
+	sort            []SortField
+	tiebreak        string
+	size            int
+	maxResultWindow int
+}
+
+// Page is a page of hits
+type Page struct {
+	Hits []Hit
+	// Total is the number of matching documents, counted exactly, and TotalRelation is "eq" then
+	Total         int64
+	TotalRelation string
+	// Next is the cursor token of the next page, empty on the last page
+	Next string
+	// Number is the number of the page from 1 and Pages is the number of pages reachable by number,
+	// both are set by PageAt only. Next of a numbered page continues by cursors.
+	Number int
+	Pages  int
+}
+
+// NewPager creates a Pager of size hits per page sorted by the fields, 10 hits if size isn't positive
+func (Es *Client) NewPager(index string, query []byte, size int, sort ...SortField) *Pager {
+	if size <= 0 {
+		size = 10
+	}
+	return &Pager{
+		es:              Es,
+		index:           index,
+		query:           Es.notDeleted(query),
+		sort:            sort,
+		tiebreak:        "_id",
+		size:            size,
+		maxResultWindow: defaultMaxResultWindow,
+	}
+}
+
+// Tiebreak sets the field completing the sort instead of _id, it must be unique and sortable, like a keyword copy of the id
+func (p *Pager) Tiebreak(field string) *Pager {
+	p.tiebreak = field
+	return p
+}
+
+// order returns the sort completed with the tiebreak field
+func (p *Pager) order() []SortField {
+	return withTiebreak(p.sort, p.tiebreak)
+}
+
+// withTiebreak appends the ascending field to the sort unless it's there
+func withTiebreak(sort []SortField, field string) []SortField {
+	for _, s := range sort {
+		if s.Field == field {
+			return sort
+		}
+	}
+	return append(append([]SortField(nil), sort...), Asc(field))
+}
+
+// MaxResultWindow sets index.max_result_window of the index if it's changed from the default 10000
+func (p *Pager) MaxResultWindow(n int) *Pager {
+	p.maxResultWindow = n
+	return p
+}
+
+// Page returns the page after the cursor token, the first one for an empty cursor.
+// A malformed cursor gives ErrBadCursor.
+func (p *Pager) Page(cursor string) (page *Page, err error) {
+	ctx, span := p.es.startSpan("page", p.index, "")
+	defer func() { endSpan(span, err) }()
+
+	order := p.order()
+	opts := []SearchOption{Size(p.size + 1), Sort(order...), ExactTotal()}
+	if cursor != "" {
+		after, err := decodeCursor(cursor, len(order))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, SearchAfter(after...))
+	}
+
+	body, err := newSearchBody(p.query, opts)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := p.es.search(ctx, p.index, body)
+	if err != nil {
+		return nil, err
+	}
+
+	page = &Page{Hits: sr.Hits, Total: sr.Total, TotalRelation: sr.TotalRelation}
+	// one hit more than the size tells there's a next page
+	if len(page.Hits) > p.size {
+		page.Hits = page.Hits[:p.size]
+		if page.Next, err = encodeCursor(page.Hits[p.size-1].Sort); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// PageAt returns the page by its number from 1. Pages beyond max_result_window
+// give ErrResultWindow, use cursors to go deeper.
+func (p *Pager) PageAt(number int) (page *Page, err error) {
+	ctx, span := p.es.startSpan("page", p.index, "")
+	defer func() { endSpan(span, err) }()
+
+	if number < 1 {
+		return nil, fmt.Errorf("page number must be positive, got %d", number)
+	}
+	from := (number - 1) * p.size
+	if from+p.size > p.maxResultWindow {
+		return nil, fmt.Errorf("%w: page %d ends at %d, max_result_window is %d", ErrResultWindow, number, from+p.size, p.maxResultWindow)
+	}
+
+	body, err := newSearchBody(p.query, []SearchOption{From(from), Size(p.size), Sort(p.order()...), ExactTotal()})
+	if err != nil {
+		return nil, err
+	}
+	sr, err := p.es.search(ctx, p.index, body)
+	if err != nil {
+		return nil, err
+	}
+
+	page = &Page{Hits: sr.Hits, Total: sr.Total, TotalRelation: sr.TotalRelation, Number: number}
+	total := sr.Total
+	if total > int64(p.maxResultWindow) {
+		total = int64(p.maxResultWindow)
+	}
+	page.Pages = int((total + int64(p.size) - 1) / int64(p.size))
+	if int64(from+len(page.Hits)) < sr.Total && len(page.Hits) > 0 {
+		if page.Next, err = encodeCursor(page.Hits[len(page.Hits)-1].Sort); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func encodeCursor(sort []interface{}) (string, error) {
+	b, err := json.Marshal(sort)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode cursor: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(cursor string, n int) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCursor, err)
+	}
+	var after []interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&after); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCursor, err)
+	}
+	if len(after) != n {
+		return nil, fmt.Errorf("%w: %d sort values instead of %d", ErrBadCursor, len(after), n)
+	}
+	return after, nil
+}
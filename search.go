@@ -0,0 +1,319 @@
+package escrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// SearchResult is the decoded answer of the search API
+type SearchResult struct {
+	Took     int
+	TimedOut bool
+	// Total is the number of matching documents, a lower bound if TotalRelation is "gte"
+	Total         int64
+	TotalRelation string
+	MaxScore      *float64
+	Hits          []Hit
+	Aggregations  Aggregations
+	// Suggestions are the results of the suggesters by name
+	Suggestions map[string][]Suggestion
+}
+
+// Hit is a found document
+type Hit struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Score  *float64        `json:"_score"`
+	Source json.RawMessage `json:"_source,omitempty"`
+	// Sort holds the sort values of the hit when the search is sorted, numbers are json.Number
+	// so long values keep their precision
+	Sort []interface{} `json:"sort,omitempty"`
+	// Highlight holds the snippets of the fields asked by Highlight
+	Highlight map[string][]string `json:"highlight,omitempty"`
+}
+
+// UnmarshalJSON decodes the hit keeping the sort values as written
+func (h *Hit) UnmarshalJSON(data []byte) error {
+	type hit Hit
+	var raw struct {
+		hit
+		Sort json.RawMessage `json:"sort"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*h = Hit(raw.hit)
+	if len(raw.Sort) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw.Sort))
+	dec.UseNumber()
+	return dec.Decode(&h.Sort)
+}
+
+// hitsSection is the hits object of search and top_hits answers
+type hitsSection struct {
+	Total struct {
+		Value    int64  `json:"value"`
+		Relation string `json:"relation"`
+	} `json:"total"`
+	MaxScore *float64 `json:"max_score"`
+	Hits     []Hit    `json:"hits"`
+}
+
+type searchResponse struct {
+	Took         int                     `json:"took"`
+	TimedOut     bool                    `json:"timed_out"`
+	Hits         hitsSection             `json:"hits"`
+	Aggregations Aggregations            `json:"aggregations"`
+	Suggest      map[string][]Suggestion `json:"suggest"`
+}
+
+// SortField is a field to sort hits by
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Asc sorts by the field in ascending order
+func Asc(field string) SortField {
+	return SortField{Field: field}
+}
+
+// Desc sorts by the field in descending order
+func Desc(field string) SortField {
+	return SortField{Field: field, Desc: true}
+}
+
+// MarshalJSON encodes the field as an item of the sort list
+func (s SortField) MarshalJSON() ([]byte, error) {
+	order := "asc"
+	if s.Desc {
+		order = "desc"
+	}
+	return json.Marshal(map[string]interface{}{s.Field: map[string]string{"order": order}})
+}
+
+// searchBody is the request body of the search API
+type searchBody struct {
+	Query  json.RawMessage `json:"query,omitempty"`
+	From   *int            `json:"from,omitempty"`
+	Size   *int            `json:"size,omitempty"`
+	Sort   []SortField     `json:"sort,omitempty"`
+	Source interface{}     `json:"_source,omitempty"`
+	Aggs   map[string]*Agg `json:"aggs,omitempty"`
+
+	SearchAfter    []interface{}         `json:"search_after,omitempty"`
+	TrackTotalHits bool                  `json:"track_total_hits,omitempty"`
+	Highlight      *Highlighter          `json:"highlight,omitempty"`
+	Suggest        map[string]*Suggester `json:"suggest,omitempty"`
+}
+
+// SearchOption configures a search
+type SearchOption func(*searchBody)
+
+// From skips the first n hits
+func From(n int) SearchOption {
+	return func(b *searchBody) {
+		b.From = &n
+	}
+}
+
+// Size sets the number of hits to return, 10 by default
+func Size(n int) SearchOption {
+	return func(b *searchBody) {
+		b.Size = &n
+	}
+}
+
+// Sort orders the hits by the fields, relevance by default
+func Sort(fields ...SortField) SearchOption {
+	return func(b *searchBody) {
+		b.Sort = append(b.Sort, fields...)
+	}
+}
+
+// SearchAfter returns the hits following the hit with the sort values, see Hit.Sort.
+// NewPager wraps it into cursor tokens.
+func SearchAfter(values ...interface{}) SearchOption {
+	return func(b *searchBody) {
+		b.SearchAfter = values
+	}
+}
+
+// ExactTotal counts all the matching documents, elastic stops counting at 10000 by default
+// and reports SearchResult.TotalRelation "gte" then
+func ExactTotal() SearchOption {
+	return func(b *searchBody) {
+		b.TrackTotalHits = true
+	}
+}
+
+// SourceFields returns only the fields of the documents in Hit.Source
+func SourceFields(fields ...string) SearchOption {
+	return func(b *searchBody) {
+		b.Source = fields
+	}
+}
+
+// Aggs adds aggregations to the search, read them from SearchResult.Aggregations by name
+func Aggs(aggs ...*Agg) SearchOption {
+	return func(b *searchBody) {
+		if b.Aggs == nil {
+			b.Aggs = map[string]*Agg{}
+		}
+		for _, a := range aggs {
+			b.Aggs[a.Name] = a
+		}
+	}
+}
+
+// newSearchBody encodes the query with the options, an empty query matches all documents
+func newSearchBody(query []byte, opts []SearchOption) ([]byte, error) {
+	b := &searchBody{Query: query}
+	for _, opt := range opts {
+		opt(b)
+	}
+	body, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode search: %v", err)
+	}
+	return body, nil
+}
+
+// Search найти документы по запросу query (JSON объект query DSL, пустой - все документы)
+// аналог запроса
+// POST http://localhost:9200/article/_search
+// { "query": {{query}}, "size": 10, "aggs": {...} }
+//
+//	res, err := es.Search("article", []byte(`{"term": {"rubric": "news"}}`), Size(20), Sort(Desc("date")),
+//		Aggs(TermsAgg("authors", "author", 10).Sub(SumAgg("views", "viewed"))))
+func (Es *Client) Search(index string, query []byte, opts ...SearchOption) (sr *SearchResult, err error) {
+	ctx, span := Es.startSpan("search", index, "")
+	defer func() { endSpan(span, err) }()
+
+	body, err := newSearchBody(Es.notDeleted(query), opts)
+	if err != nil {
+		return nil, err
+	}
+	return Es.search(ctx, index, body)
+}
+
+// Count посчитать документы, подходящие под запрос query (пустой - все документы), не получая их
+// аналог запроса
+// POST http://localhost:9200/article/_count
+// { "query": {{query}} }
+func (Es *Client) Count(index string, query []byte) (n int64, err error) {
+	ctx, span := Es.startSpan("count", index, "")
+	defer func() { endSpan(span, err) }()
+
+	body, err := newSearchBody(Es.notDeleted(query), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	es := Es.Client
+	opts := []func(*esapi.CountRequest){
+		es.Count.WithContext(ctx),
+		es.Count.WithBody(bytes.NewReader(body)),
+	}
+	if index != "" {
+		opts = append(opts, es.Count.WithIndex(index))
+	}
+
+	res, err := es.Count(opts...)
+	if err != nil {
+		return 0, fmt.Errorf("cannot count: %v", err)
+	}
+	defer res.Body.Close()
+	spanStatus(ctx, res)
+
+	resp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read response body: %v", err)
+	}
+
+	if res.IsError() {
+		return 0, fmt.Errorf("count failed. Status: %s, err: %s", res.Status(), resp)
+	}
+
+	var cr struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.Unmarshal(resp, &cr); err != nil {
+		return 0, fmt.Errorf("response contains bad json: %v", err)
+	}
+	return cr.Count, nil
+}
+
+// ExistsByQuery проверить, есть ли хоть один документ, подходящий под запрос query.
+// Поиск останавливается на первом найденном документе (terminate_after=1) и не возвращает его (size=0).
+func (Es *Client) ExistsByQuery(index string, query []byte) (ok bool, err error) {
+	ctx, span := Es.startSpan("exists_by_query", index, "")
+	defer func() { endSpan(span, err) }()
+
+	body, err := newSearchBody(Es.notDeleted(query), []SearchOption{Size(0)})
+	if err != nil {
+		return false, err
+	}
+
+	sr, err := Es.search(ctx, index, body, Es.Client.Search.WithTerminateAfter(1))
+	if err != nil {
+		return false, err
+	}
+	return sr.Total > 0, nil
+}
+
+// search sends the body to the search API of the index, empty index searches all indices
+func (Es *Client) search(ctx context.Context, index string, body []byte, params ...func(*esapi.SearchRequest)) (*SearchResult, error) {
+	es := Es.Client
+	opts := []func(*esapi.SearchRequest){
+		es.Search.WithContext(ctx),
+		es.Search.WithBody(bytes.NewReader(body)),
+	}
+	if index != "" {
+		opts = append(opts, es.Search.WithIndex(index))
+	}
+
+	res, err := es.Search(append(opts, params...)...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search: %v", err)
+	}
+	defer res.Body.Close()
+	spanStatus(ctx, res)
+
+	resp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response body: %v", err)
+	}
+
+	if res.IsError() {
+		return nil, fmt.Errorf("search failed. Status: %s, err: %s", res.Status(), resp)
+	}
+
+	var sr searchResponse
+	if err := json.Unmarshal(resp, &sr); err != nil {
+		return nil, fmt.Errorf("response contains bad json: %v", err)
+	}
+
+	return sr.result(), nil
+}
+
+// result converts the answer to SearchResult
+func (sr *searchResponse) result() *SearchResult {
+	return &SearchResult{
+		Took:          sr.Took,
+		TimedOut:      sr.TimedOut,
+		Total:         sr.Hits.Total.Value,
+		TotalRelation: sr.Hits.Total.Relation,
+		MaxScore:      sr.Hits.MaxScore,
+		Hits:          sr.Hits.Hits,
+		Aggregations:  sr.Aggregations,
+		Suggestions:   sr.Suggest,
+	}
+}
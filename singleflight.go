@@ -0,0 +1,66 @@
+package escrud
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// flightGroup collapses concurrent calls with the same key into one
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// do calls fn once for all concurrent callers of the key, shared tells
+// if the result went to other callers too
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (v interface{}, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, true, c.err
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	c.wg.Done()
+	return c.val, false, c.err
+}
+
+// WithSingleflight makes concurrent Read, Source and Exists of the same document share
+// one request to elastic. The request runs with the context of the first caller,
+// so its cancellation fails the others too. Each caller gets its own copy of ResponseBody
+// and of the source bytes, but ResponseBody.Source is shared, don't change it.
+func WithSingleflight() Option {
+	return func(c *Client) {
+		c.flight = &flightGroup{calls: map[string]*flightCall{}}
+	}
+}
+
+// coalesce runs fn by the flight group if it's on, marking the span of the callers which got a shared result.
+// The result is the same for all the callers, copy it before giving out.
+func (Es *Client) coalesce(span trace.Span, op, index, id string, fn func() (interface{}, error)) (interface{}, error) {
+	if Es.flight == nil {
+		return fn()
+	}
+	v, shared, err := Es.flight.do(op+"\x00"+index+"\x00"+id, fn)
+	if shared {
+		span.SetAttributes(attribute.Bool("escrud.shared", true))
+	}
+	return v, err
+}
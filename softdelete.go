@@ -0,0 +1,232 @@
+package escrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Fields of the soft delete mark
+const (
+	DeletedField   = "deleted"
+	DeletedAtField = "deleted_at"
+)
+
+// deletedAtLayout is fixed width, so the marks compare as strings too
+const deletedAtLayout = "2006-01-02T15:04:05.000Z"
+
+// WithSoftDelete makes Delete mark documents deleted, setting DeletedField to true and DeletedAtField
+// to the time of the delete, instead of deleting them. Read, Source and UpdateDiff of a marked document fail with
+// ErrDeleted, Exists returns false, Search, Count, ExistsByQuery, MultiSearch, Pager, Export and Copy
+// skip marked documents. The delete actions of BulkCreate and Bulk mark the documents the same way.
+// Restore unmarks a document, Purge deletes the marked ones for good.
+// Use a Client without the option to see the marked documents.
+//
+// A marked document still holds its id: Create with the id fails with ErrAlreadyExists,
+// though Exists returns false. Index overwrites the document, Restore or Purge it to create it anew.
+func WithSoftDelete() Option {
+	return func(c *Client) {
+		c.softDelete = true
+	}
+}
+
+// deleteMark is the partial document marking a document deleted
+func deleteMark(now time.Time) []byte {
+	mark, _ := json.Marshal(map[string]interface{}{
+		DeletedField:   true,
+		DeletedAtField: now.UTC().Format(deletedAtLayout),
+	})
+	return mark
+}
+
+// markBulk turns the delete actions of the bulk request into updates with the soft delete mark.
+// It returns the request as is without WithSoftDelete, marked has the numbers of the turned actions.
+func (Es *Client) markBulk(datum []byte, now time.Time) (body []byte, marked map[int]bool) {
+	if !Es.softDelete {
+		return datum, nil
+	}
+	doc := []byte(`{"doc": ` + string(deleteMark(now)) + `}`)
+	lines := bytes.Split(datum, []byte("\n"))
+	out := make([][]byte, 0, len(lines))
+	n := 0
+	for i := 0; i < len(lines); i++ {
+		var action map[string]json.RawMessage
+		if json.Unmarshal(lines[i], &action) != nil || len(action) != 1 {
+			out = append(out, lines[i])
+			continue
+		}
+		for name, meta := range action {
+			switch name {
+			case "delete":
+				if marked == nil {
+					marked = make(map[int]bool)
+				}
+				marked[n] = true
+				out = append(out, []byte(`{"update": `+string(meta)+`}`), doc)
+			case "index", "create", "update":
+				out = append(out, lines[i])
+				if i+1 < len(lines) {
+					i++
+					out = append(out, lines[i])
+				}
+			default:
+				out = append(out, lines[i])
+			}
+		}
+		n++
+	}
+	if marked == nil {
+		return datum, nil
+	}
+	return bytes.Join(out, []byte("\n")), marked
+}
+
+// isDeleted tells if the source has the soft delete mark
+func isDeleted(src interface{}) bool {
+	m, ok := src.(map[string]interface{})
+	return ok && m[DeletedField] == true
+}
+
+// checkDeleted returns ErrDeleted for a marked source in the soft delete mode
+func (Es *Client) checkDeleted(index, id string, src interface{}) error {
+	if Es.softDelete && isDeleted(src) {
+		return fmt.Errorf("%w: %s/%s", ErrDeleted, index, id)
+	}
+	return nil
+}
+
+// checkDeletedSource is checkDeleted for the source bytes
+func (Es *Client) checkDeletedSource(index, id string, src []byte) error {
+	if !Es.softDelete {
+		return nil
+	}
+	var doc map[string]interface{}
+	if json.Unmarshal(src, &doc) != nil {
+		return nil
+	}
+	return Es.checkDeleted(index, id, doc)
+}
+
+// notDeleted adds the exclusion of marked documents to the query in the soft delete mode
+func (Es *Client) notDeleted(query []byte) []byte {
+	if !Es.softDelete {
+		return query
+	}
+	mustNot := fmt.Sprintf(`[{"term": {%q: true}}]`, DeletedField)
+	if len(bytes.TrimSpace(query)) == 0 {
+		return []byte(`{"bool": {"must_not": ` + mustNot + `}}`)
+	}
+	return []byte(`{"bool": {"must": [` + string(query) + `], "must_not": ` + mustNot + `}}`)
+}
+
+// Restore removes the soft delete mark of the document, setting DeletedField to false and DeletedAtField to null
+func (Es *Client) Restore(index, id string, opts ...WriteOption) (rb *ResponseBody, err error) {
+	ctx, span := Es.startSpan("restore", index, id)
+	defer func() { endSpan(span, err) }()
+
+	data, _ := json.Marshal(map[string]interface{}{DeletedField: false, DeletedAtField: nil})
+	a := Es.startAudit(ctx, "restore", index, id, true)
+	defer func() { a.finish(data, rb, err) }()
+
+	return update(ctx, Es.Client, index, id, data, Es.write(opts))
+}
+
+// Purge deletes the documents of the index marked deleted more than retention ago and returns their number.
+// With WithAudit it's recorded as a whole, with the request in Data and the number in Count.
+// аналог запроса
+// POST http://localhost:9200/article/_delete_by_query
+// { "query": { "bool": { "filter": [ {"term": {"deleted": true}}, {"range": {"deleted_at": {"lt": {{now - retention}}}}} ] } } }
+func (Es *Client) Purge(index string, retention time.Duration) (n int64, err error) {
+	ctx, span := Es.startSpan("purge", index, "")
+	defer func() { endSpan(span, err) }()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{DeletedField: true}},
+					map[string]interface{}{"range": map[string]interface{}{
+						DeletedAtField: map[string]interface{}{"lt": time.Now().Add(-retention).UTC().Format(deletedAtLayout)},
+					}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot encode query: %v", err)
+	}
+	a := Es.startAudit(ctx, "purge", index, "", false)
+	defer func() {
+		if a != nil {
+			a.rec.Count = n
+			if err == nil {
+				a.rec.Result = "deleted"
+			}
+		}
+		a.finish(body, nil, err)
+	}()
+
+	es := Es.Client
+	res, err := es.DeleteByQuery(
+		[]string{index},
+		bytes.NewReader(body),
+		es.DeleteByQuery.WithContext(ctx),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("cannot purge: %v", err)
+	}
+	defer res.Body.Close()
+	spanStatus(ctx, res)
+
+	resp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read response body: %v", err)
+	}
+
+	if res.IsError() {
+		return 0, fmt.Errorf("purge failed. Status: %s, err: %s", res.Status(), resp)
+	}
+
+	var dr struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.Unmarshal(resp, &dr); err != nil {
+		return 0, fmt.Errorf("response contains bad json: %v", err)
+	}
+	return dr.Deleted, nil
+}
+
+// PurgeEvery runs Purge of the indices every interval until stop is called, errors are logged
+//
+//	stop := es.PurgeEvery(time.Hour, 30*24*time.Hour, "article", "mask")
+//	defer stop()
+func (Es *Client) PurgeEvery(interval, retention time.Duration, indices ...string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			for _, index := range indices {
+				n, err := Es.Purge(index, retention)
+				if err != nil {
+					Es.log().Error("cannot purge deleted documents", "index", index, "error", err)
+					continue
+				}
+				Es.log().Info("purged deleted documents", "index", index, "count", n)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,95 @@
+package escrud
+
+import "encoding/json"
+
+// Suggester is a term, phrase or completion suggester of a search,
+// its results come in SearchResult.Suggestions by name
+type Suggester struct {
+	Name   string
+	typ    string
+	text   string
+	prefix string
+	params map[string]interface{}
+}
+
+// TermSuggester suggests corrections of each word of the text by the terms of the field
+func TermSuggester(name, text, field string) *Suggester {
+	return &Suggester{Name: name, typ: "term", text: text, params: map[string]interface{}{"field": field}}
+}
+
+// PhraseSuggester suggests corrections of the whole text by the field, for "did you mean"
+func PhraseSuggester(name, text, field string) *Suggester {
+	return &Suggester{Name: name, typ: "phrase", text: text, params: map[string]interface{}{"field": field}}
+}
+
+// CompletionSuggester suggests size documents by the prefix of the field, which must be mapped as completion
+func CompletionSuggester(name, prefix, field string, size int) *Suggester {
+	return &Suggester{Name: name, typ: "completion", prefix: prefix, params: map[string]interface{}{"field": field, "size": size}}
+}
+
+// Param sets a parameter of the suggester which has no helper, e.g. suggest_mode or skip_duplicates
+func (s *Suggester) Param(key string, value interface{}) *Suggester {
+	s.params[key] = value
+	return s
+}
+
+// MarshalJSON encodes the suggester as the value of its name in "suggest"
+func (s *Suggester) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{s.typ: s.params}
+	if s.typ == "completion" {
+		body["prefix"] = s.prefix
+	} else {
+		body["text"] = s.text
+	}
+	return json.Marshal(body)
+}
+
+// Suggest adds suggesters to the search
+func Suggest(suggesters ...*Suggester) SearchOption {
+	return func(b *searchBody) {
+		if b.Suggest == nil {
+			b.Suggest = map[string]*Suggester{}
+		}
+		for _, s := range suggesters {
+			b.Suggest[s.Name] = s
+		}
+	}
+}
+
+// Suggestion is the suggestions for a piece of the text: a word for the term suggester,
+// the whole text for phrase and completion ones
+type Suggestion struct {
+	Text    string             `json:"text"`
+	Offset  int                `json:"offset"`
+	Length  int                `json:"length"`
+	Options []SuggestionOption `json:"options"`
+}
+
+// SuggestionOption is a suggested text. Freq is set by the term suggester, Highlighted by the phrase one
+// and the document fields by the completion one.
+type SuggestionOption struct {
+	Text        string          `json:"text"`
+	Score       float64         `json:"score"`
+	Freq        int             `json:"freq,omitempty"`
+	Highlighted string          `json:"highlighted,omitempty"`
+	Index       string          `json:"_index,omitempty"`
+	ID          string          `json:"_id,omitempty"`
+	Source      json.RawMessage `json:"_source,omitempty"`
+}
+
+// UnmarshalJSON decodes the option, completion options have their score in _score
+func (o *SuggestionOption) UnmarshalJSON(data []byte) error {
+	type option SuggestionOption
+	var v struct {
+		option
+		DocScore *float64 `json:"_score"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*o = SuggestionOption(v.option)
+	if v.DocScore != nil {
+		o.Score = *v.DocScore
+	}
+	return nil
+}
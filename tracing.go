@@ -0,0 +1,97 @@
+package escrud
+
+import (
+	"context"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of spans created by escrud
+const tracerName = "github.com/RGRU/escrud"
+
+var (
+	attrDBSystem   = attribute.Key("db.system")
+	attrOperation  = attribute.Key("db.operation")
+	attrIndex      = attribute.Key("db.elasticsearch.index")
+	attrDocumentID = attribute.Key("db.elasticsearch.doc_id")
+	attrStatusCode = attribute.Key("http.status_code")
+)
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used for the
+// Client and Transport spans. The global provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Client) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithContext returns a shallow copy of the client bound to ctx, a *Client for the operations outside Interface.
+// Spans of the copy are children of the span stored in ctx and requests are cancelled with it:
+// Transport stops waiting for elastic when ctx is done and cuts the request at its deadline.
+//
+//	es.WithContext(r.Context()).Read("article", id)
+//	es.WithContext(r.Context()).(*escrud.Client).Export("article", w)
+func (Es *Client) WithContext(ctx context.Context) Interface {
+	c := *Es
+	c.ctx = ctx
+	return &c
+}
+
+func (Es *Client) context() context.Context {
+	if Es.ctx == nil {
+		return context.Background()
+	}
+	return Es.ctx
+}
+
+func (Es *Client) tracer() trace.Tracer {
+	return tracer(Es.tracerProvider)
+}
+
+func tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startSpan opens a client span for a single escrud operation
+func (Es *Client) startSpan(operation, index, id string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attrDBSystem.String("elasticsearch"),
+		attrOperation.String(operation),
+	}
+	if index != "" {
+		attrs = append(attrs, attrIndex.String(index))
+	}
+	if id != "" {
+		attrs = append(attrs, attrDocumentID.String(id))
+	}
+
+	ctx := Es.context()
+	if len(Es.interceptors) > 0 {
+		ctx = withCall(ctx, operation, index, id)
+	}
+	return Es.tracer().Start(ctx, "elasticsearch."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// spanStatus adds the status code of the elastic response to the span stored in ctx
+func spanStatus(ctx context.Context, res *esapi.Response) {
+	trace.SpanFromContext(ctx).SetAttributes(attrStatusCode.Int(res.StatusCode))
+}
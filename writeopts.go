@@ -0,0 +1,114 @@
+package escrud
+
+import (
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// RefreshPolicy tells elastic when the changes made by a write become visible to search
+type RefreshPolicy string
+
+// Refresh policies, see https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-refresh.html
+const (
+	RefreshFalse   RefreshPolicy = "false"
+	RefreshTrue    RefreshPolicy = "true"
+	RefreshWaitFor RefreshPolicy = "wait_for"
+)
+
+// WriteOptions are the parameters of Create, Index, Update, Delete, the scripted updates and BulkCreate.
+// Zero values are not sent, so elastic defaults apply.
+type WriteOptions struct {
+	Refresh             RefreshPolicy
+	WaitForActiveShards string
+	Timeout             time.Duration
+	Routing             string
+	// Pipeline is the ingest pipeline, used by Create, Index and BulkCreate only
+	Pipeline string
+}
+
+// WriteOption changes WriteOptions of a single write or, passed to WithWriteOptions, of all of them
+type WriteOption func(*WriteOptions)
+
+// WithWriteOptions sets the default options of the Client writes
+func WithWriteOptions(opts ...WriteOption) Option {
+	return func(c *Client) {
+		for _, opt := range opts {
+			opt(&c.writeOptions)
+		}
+	}
+}
+
+// Refresh sets the refresh policy, e.g. RefreshWaitFor to search the document right after the write
+func Refresh(policy RefreshPolicy) WriteOption {
+	return func(o *WriteOptions) {
+		o.Refresh = policy
+	}
+}
+
+// WaitForActiveShards sets the number of shard copies that must be active before the write, "all" or a number
+func WaitForActiveShards(n string) WriteOption {
+	return func(o *WriteOptions) {
+		o.WaitForActiveShards = n
+	}
+}
+
+// Timeout sets how long the write waits for unavailable shards
+func Timeout(d time.Duration) WriteOption {
+	return func(o *WriteOptions) {
+		o.Timeout = d
+	}
+}
+
+// Routing sets the shard routing value
+func Routing(routing string) WriteOption {
+	return func(o *WriteOptions) {
+		o.Routing = routing
+	}
+}
+
+// Pipeline sets the ingest pipeline of Create, Index and BulkCreate
+func Pipeline(pipeline string) WriteOption {
+	return func(o *WriteOptions) {
+		o.Pipeline = pipeline
+	}
+}
+
+// write returns the client defaults overridden by opts
+func (Es *Client) write(opts []WriteOption) WriteOptions {
+	o := Es.writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o WriteOptions) index(r *esapi.IndexRequest) {
+	r.Refresh = string(o.Refresh)
+	r.WaitForActiveShards = o.WaitForActiveShards
+	r.Timeout = o.Timeout
+	r.Routing = o.Routing
+	r.Pipeline = o.Pipeline
+}
+
+func (o WriteOptions) update(r *esapi.UpdateRequest) {
+	r.Refresh = string(o.Refresh)
+	r.WaitForActiveShards = o.WaitForActiveShards
+	r.Timeout = o.Timeout
+	r.Routing = o.Routing
+}
+
+func (o WriteOptions) delete(r *esapi.DeleteRequest) {
+	r.Refresh = string(o.Refresh)
+	r.WaitForActiveShards = o.WaitForActiveShards
+	r.Timeout = o.Timeout
+	r.Routing = o.Routing
+}
+
+func (o WriteOptions) bulk(r *esapi.BulkRequest) {
+	r.Refresh = string(o.Refresh)
+	r.WaitForActiveShards = o.WaitForActiveShards
+	r.Timeout = o.Timeout
+	r.Routing = o.Routing
+	r.Pipeline = o.Pipeline
+}